@@ -8,7 +8,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/spf13/viper"
+	"github.com/anonfunc/transmissio/internal/pkg/config"
 
 	"github.com/anonfunc/transmissio/internal/pkg/torrent"
 
@@ -53,7 +53,7 @@ func handle(downloader *torrent.PutIoDownloader, event watcher.Event, basePath s
 	if event.Op != watcher.Create && event.Op != watcher.Write {
 		return
 	}
-	downloadDir := blackholePathToDownloadDir(event.Path, basePath, viper.GetString("downloadTo"))
+	downloadDir := blackholePathToDownloadDir(event.Path, basePath, config.GetString("downloadTo"))
 	ext := path.Ext(event.Path)
 	switch ext {
 	case ".torrent":
@@ -1,6 +1,15 @@
 package blackhole
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/anonfunc/transmissio/internal/pkg/torrent"
+	"github.com/radovskyb/watcher"
+)
 
 func Test_blackholePathToDownloadDir(t *testing.T) {
 	type args struct {
@@ -59,3 +68,46 @@ func Test_blackholePathToDownloadDir(t *testing.T) {
 		})
 	}
 }
+
+func Test_handle_magnetFile(t *testing.T) {
+	const magnet = "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Show"
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"No newline", magnet},
+		{"Newline", magnet + "\n"},
+		{"CRLF and spaces", "  " + magnet + "\r\n"},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "blackhole")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			reg, err := registry.Open(filepath.Join(dir, "registry.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Already registered, so it's recognised without asking put.io.
+			if _, _, err := reg.Add("c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "Show", magnet, dir); err != nil {
+				t.Fatal(err)
+			}
+			downloader := &torrent.PutIoDownloader{Registry: reg, Results: make(chan torrent.FetchResult, 1)}
+			file := filepath.Join(dir, "show.magnet")
+			if err := ioutil.WriteFile(file, []byte(tt.contents), 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			handle(downloader, watcher.Event{Op: watcher.Create, Path: file}, dir)
+			if result := <-downloader.Results; result.Error != torrent.ErrDuplicate {
+				t.Errorf("fetch error = %v, want %v", result.Error, torrent.ErrDuplicate)
+			}
+			if _, err := os.Stat(file + ".duplicate"); err != nil {
+				t.Errorf("magnet file not renamed .duplicate: %v", err)
+			}
+		})
+	}
+}
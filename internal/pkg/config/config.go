@@ -2,6 +2,7 @@ package config
 
 import (
 	"log"
+	"path/filepath"
 
 	"github.com/spf13/viper"
 )
@@ -29,5 +30,50 @@ func setDefaults() {
 	viper.SetDefault("downloadTo", "/download")
 	viper.SetDefault("host", "")
 	viper.SetDefault("port", "9091")
+	viper.SetDefault("registry", "registry.json")
+	viper.SetDefault("stats", "stats.json")
+	viper.SetDefault("putioFreeSpace", false)
+	viper.SetDefault("downloadOrder", "listing") // Or smallest, largest or natural.
+	viper.SetDefault("putioPollInterval", "5s")  // How often torrent-get's view of put.io is refreshed.
+	// Transmission session settings, see session-get.
+	viper.SetDefault("altSpeedDown", 50)
+	viper.SetDefault("altSpeedEnabled", false)
+	viper.SetDefault("altSpeedTimeBegin", 540)
+	viper.SetDefault("altSpeedTimeDay", 127)
+	viper.SetDefault("altSpeedTimeEnabled", false)
+	viper.SetDefault("altSpeedTimeEnd", 1020)
+	viper.SetDefault("altSpeedUp", 50)
+	viper.SetDefault("downloadQueueEnabled", true)
+	viper.SetDefault("downloadQueueSize", 5)
+	viper.SetDefault("incompleteDir", "")
+	viper.SetDefault("incompleteDirEnabled", false)
+	viper.SetDefault("renamePartialFiles", false)
+	viper.SetDefault("seedQueueEnabled", false)
+	viper.SetDefault("seedQueueSize", 10)
+	viper.SetDefault("speedLimitDown", 10000)
+	viper.SetDefault("speedLimitDownEnabled", false)
+	viper.SetDefault("speedLimitUp", 10000)
+	viper.SetDefault("speedLimitUpEnabled", false)
+	viper.SetDefault("startAddedTorrents", true)
+	viper.SetDefault("trashOriginalTorrentFiles", false)
+	// Transmission RPC access control.
+	viper.SetDefault("rpcAuthenticationRequired", false)
+	viper.SetDefault("rpcUsername", "")
+	viper.SetDefault("rpcPassword", "") // Hashed on startup.
+	viper.SetDefault("rpcWhitelistEnabled", false)
+	viper.SetDefault("rpcWhitelist", "127.0.0.1,::1")
+	viper.SetDefault("rpcHostWhitelistEnabled", false)
+	viper.SetDefault("rpcHostWhitelist", "")
+	viper.SetDefault("sessionIdRotation", "0s") // e.g. 24h, or 0s to keep the session ID until restart.
 	viper.SetDefault("oauth_token", "Get from https://app.put.io/settings/account/oauth/apps")
 }
+
+// Path returns the file named by the config key, resolving relative paths
+// against the directory holding config.yaml.
+func Path(key string) string {
+	path := GetString(key)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(viper.ConfigFileUsed()), path)
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// settings guards viper, which isn't safe for concurrent use, as session-set
+// changes settings while handlers, the download queue and the put.io poller
+// read them.  Once running, settings are only read and changed through here.
+var settings sync.RWMutex
+
+func GetString(key string) string {
+	settings.RLock()
+	defer settings.RUnlock()
+	return viper.GetString(key)
+}
+
+func GetBool(key string) bool {
+	settings.RLock()
+	defer settings.RUnlock()
+	return viper.GetBool(key)
+}
+
+func GetInt(key string) int {
+	settings.RLock()
+	defer settings.RUnlock()
+	return viper.GetInt(key)
+}
+
+func GetInt64(key string) int64 {
+	settings.RLock()
+	defer settings.RUnlock()
+	return viper.GetInt64(key)
+}
+
+func GetDuration(key string) time.Duration {
+	settings.RLock()
+	defer settings.RUnlock()
+	return viper.GetDuration(key)
+}
+
+// Set changes settings and saves them to the config file.  Only the changed
+// keys are added to what the file already has, so that defaults and
+// environment overrides, such as T_OAUTH_TOKEN, aren't written out.
+func Set(changes map[string]interface{}) error {
+	settings.Lock()
+	defer settings.Unlock()
+	for key, value := range changes {
+		viper.Set(key, value)
+	}
+	file := viper.New()
+	file.SetConfigFile(viper.ConfigFileUsed())
+	if err := file.ReadInConfig(); err != nil {
+		return err
+	}
+	for key, value := range changes {
+		file.Set(key, value)
+	}
+	return file.WriteConfig()
+}
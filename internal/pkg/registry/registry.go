@@ -0,0 +1,387 @@
+// Package registry keeps the stable Transmission IDs handed out for each
+// infohash, along with what we know about the matching put.io transfer.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	ID          int64     `json:"id"`
+	Hash        string    `json:"hash"`
+	Name        string    `json:"name"`
+	Source      string    `json:"source"`
+	DownloadDir string    `json:"downloadDir"`
+	TransferID  int64     `json:"transferId,omitempty"`
+	FileID      int64     `json:"fileId,omitempty"`
+	AddedDate   time.Time `json:"addedDate"`
+	Paused      bool      `json:"paused,omitempty"`
+	Renames     []Rename  `json:"renames,omitempty"`
+	DoneDate    time.Time `json:"doneDate"`           // When the local copy completed.
+	External    bool      `json:"external,omitempty"` // Added on put.io, so not downloaded unless started.
+	Labels      []string  `json:"labels,omitempty"`
+	// BandwidthPriority orders the local downloads: -1 low, 0 normal, 1 high.
+	BandwidthPriority int64 `json:"bandwidthPriority,omitempty"`
+	// Unwanted are the indices, into put.io's file list, of files not to download locally.
+	Unwanted []int `json:"unwanted,omitempty"`
+	// Priorities are the -1 low or 1 high priorities of files, by index.
+	Priorities map[int]int64 `json:"priorities,omitempty"`
+	// QueuePosition is the torrent's place in the local download queue, from 0.
+	QueuePosition int `json:"queuePosition"`
+	// Error is why the torrent stopped, as a Transmission error type, until it's started again.
+	Error       int64  `json:"error,omitempty"`
+	ErrorString string `json:"errorString,omitempty"`
+	// LocalPath is the torrent's top level file or directory, once its local download started.
+	LocalPath string `json:"localPath,omitempty"`
+}
+
+// Priority is the file at index's priority: -1 low, 0 normal, 1 high.
+func (e Entry) Priority(index int) int64 {
+	return e.Priorities[index]
+}
+
+// SetPriority sets the priority of the files at indices.  The map is
+// replaced rather than changed, as copies of the entry share it.
+func (e *Entry) SetPriority(indices []int, priority int64) {
+	priorities := make(map[int]int64, len(e.Priorities)+len(indices))
+	for index, p := range e.Priorities {
+		priorities[index] = p
+	}
+	for _, index := range indices {
+		if priority == 0 {
+			delete(priorities, index)
+		} else {
+			priorities[index] = priority
+		}
+	}
+	e.Priorities = priorities
+	if len(priorities) == 0 {
+		e.Priorities = nil
+	}
+}
+
+// Wanted reports whether the file at index should be downloaded locally.
+func (e Entry) Wanted(index int) bool {
+	i := sort.SearchInts(e.Unwanted, index)
+	return i == len(e.Unwanted) || e.Unwanted[i] != index
+}
+
+// SetWanted marks the files at indices as wanted or not.
+func (e *Entry) SetWanted(indices []int, wanted bool) {
+	unwanted := make(map[int]bool, len(e.Unwanted)+len(indices))
+	for _, index := range e.Unwanted {
+		unwanted[index] = true
+	}
+	for _, index := range indices {
+		unwanted[index] = !wanted
+	}
+	e.Unwanted = nil
+	for index, ok := range unwanted {
+		if ok {
+			e.Unwanted = append(e.Unwanted, index)
+		}
+	}
+	sort.Ints(e.Unwanted)
+}
+
+// Rename is a torrent-rename-path request: the last element of Path, a
+// slash separated path within the torrent, is renamed to Name.
+type Rename struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+}
+
+// RenamedPath applies the torrent's renames, in order, to a path within it.
+func (e Entry) RenamedPath(p string) string {
+	for _, rename := range e.Renames {
+		if p == rename.Path || strings.HasPrefix(p, rename.Path+"/") {
+			p = path.Join(path.Dir(rename.Path), rename.Name) + p[len(rename.Path):]
+		}
+	}
+	return p
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	path    string
+	lastID  int64
+	entries map[string]*Entry // By hash.
+	removed []removal
+}
+
+type removal struct {
+	entry     Entry
+	at        time.Time
+	completed bool
+}
+
+// How long removals are remembered for RemovedSince and RecentlyCompleted.
+const removalHistory = time.Hour
+
+type registryFile struct {
+	LastID   int64   `json:"lastId"`
+	Torrents []Entry `json:"torrents"`
+}
+
+// Open loads the registry at path, starting an empty one if it doesn't exist yet.
+func Open(path string) (*Registry, error) {
+	r := &Registry{path: path, entries: make(map[string]*Entry)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f registryFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("unable to parse registry %s: %s", path, err.Error())
+	}
+	r.lastID = f.LastID
+	for i := range f.Torrents {
+		entry := f.Torrents[i]
+		r.entries[entry.Hash] = &entry
+		if entry.ID > r.lastID {
+			r.lastID = entry.ID
+		}
+	}
+	r.renumberQueue(r.queueOrder())
+	return r, nil
+}
+
+// Add registers hash under the next free ID.  If hash is already registered,
+// the existing entry is returned and nothing changes.
+func (r *Registry) Add(hash, name, source, downloadDir string) (Entry, bool, error) {
+	hash = strings.ToLower(hash)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.entries[hash]; ok {
+		return *existing, false, nil
+	}
+	r.lastID++
+	entry := &Entry{
+		ID:          r.lastID,
+		Hash:        hash,
+		Name:        name,
+		Source:      source,
+		DownloadDir: downloadDir,
+		AddedDate:   time.Now(),
+	}
+	entry.QueuePosition = len(r.entries)
+	r.entries[hash] = entry
+	return *entry, true, r.save()
+}
+
+func (r *Registry) ByHash(hash string) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[strings.ToLower(hash)]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+func (r *Registry) ByID(id int64) (Entry, bool) {
+	return r.find(func(e *Entry) bool { return e.ID == id })
+}
+
+func (r *Registry) ByTransferID(transferID int64) (Entry, bool) {
+	return r.find(func(e *Entry) bool { return e.TransferID == transferID })
+}
+
+func (r *Registry) find(match func(*Entry) bool) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range r.entries {
+		if match(entry) {
+			return *entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Entries returns every registered torrent, ordered by ID.
+func (r *Registry) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := make([]Entry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Update applies change to the entry for hash and saves the registry.
+func (r *Registry) Update(hash string, change func(*Entry)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.entries[strings.ToLower(hash)]
+	if !ok {
+		return fmt.Errorf("torrent %s not registered", hash)
+	}
+	change(entry)
+	return r.save()
+}
+
+func (r *Registry) Remove(hash string) error {
+	return r.remove(hash, false)
+}
+
+// Complete removes a torrent which was downloaded, remembering it for a while
+// so it isn't added again.
+func (r *Registry) Complete(hash string) error {
+	return r.remove(hash, true)
+}
+
+func (r *Registry) remove(hash string, completed bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hash = strings.ToLower(hash)
+	entry, ok := r.entries[hash]
+	if !ok {
+		return nil
+	}
+	delete(r.entries, hash)
+	r.renumberQueue(r.queueOrder())
+	now := time.Now()
+	for len(r.removed) > 0 && now.Sub(r.removed[0].at) > removalHistory {
+		r.removed = r.removed[1:]
+	}
+	r.removed = append(r.removed, removal{entry: *entry, at: now, completed: completed})
+	return r.save()
+}
+
+// RecentlyCompleted returns the entry of a torrent completed in the last hour.
+func (r *Registry) RecentlyCompleted(hash string) (Entry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hash = strings.ToLower(hash)
+	for i := len(r.removed) - 1; i >= 0; i-- {
+		removed := r.removed[i]
+		if removed.entry.Hash == hash && time.Since(removed.at) <= removalHistory {
+			return removed.entry, removed.completed
+		}
+	}
+	return Entry{}, false
+}
+
+// MoveInQueue moves torrents to the "top" or "bottom" of the queue, or one
+// place "up" or "down", as Transmission's queue-move methods do.
+func (r *Registry) MoveInQueue(hashes []string, move string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	selected := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		selected[strings.ToLower(hash)] = true
+	}
+	order, err := moveInQueue(r.queueOrder(), selected, move)
+	if err != nil {
+		return err
+	}
+	r.renumberQueue(order)
+	return r.save()
+}
+
+func moveInQueue(order []string, selected map[string]bool, move string) ([]string, error) {
+	var moved, rest []string
+	for _, hash := range order {
+		if selected[hash] {
+			moved = append(moved, hash)
+		} else {
+			rest = append(rest, hash)
+		}
+	}
+	switch move {
+	case "top":
+		return append(moved, rest...), nil
+	case "bottom":
+		return append(rest, moved...), nil
+	case "up":
+		for i := 1; i < len(order); i++ {
+			if selected[order[i]] && !selected[order[i-1]] {
+				order[i-1], order[i] = order[i], order[i-1]
+			}
+		}
+		return order, nil
+	case "down":
+		for i := len(order) - 2; i >= 0; i-- {
+			if selected[order[i]] && !selected[order[i+1]] {
+				order[i], order[i+1] = order[i+1], order[i]
+			}
+		}
+		return order, nil
+	}
+	return nil, fmt.Errorf("unknown queue move %s", move)
+}
+
+// queueOrder lists the hashes by queue position, then ID.
+func (r *Registry) queueOrder() []string {
+	entries := make([]*Entry, 0, len(r.entries))
+	for _, entry := range r.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].QueuePosition != entries[j].QueuePosition {
+			return entries[i].QueuePosition < entries[j].QueuePosition
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	order := make([]string, len(entries))
+	for i, entry := range entries {
+		order[i] = entry.Hash
+	}
+	return order
+}
+
+func (r *Registry) renumberQueue(order []string) {
+	for i, hash := range order {
+		r.entries[hash].QueuePosition = i
+	}
+}
+
+// RemovedSince returns the IDs of torrents removed after since, oldest first.
+// Only the last hour of removals is kept, and not across restarts.
+func (r *Registry) RemovedSince(since time.Time) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ids []int64
+	for _, removed := range r.removed {
+		if removed.at.After(since) {
+			ids = append(ids, removed.entry.ID)
+		}
+	}
+	return ids
+}
+
+// save writes the registry out; callers must hold r.mu.
+func (r *Registry) save() error {
+	f := registryFile{LastID: r.lastID, Torrents: make([]Entry, 0, len(r.entries))}
+	for _, entry := range r.entries {
+		f.Torrents = append(f.Torrents, *entry)
+	}
+	sort.Slice(f.Torrents, func(i, j int) bool { return f.Torrents[i].ID < f.Torrents[j].ID })
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0777); err != nil {
+		return err
+	}
+	// Write and rename, so a crash never leaves a truncated registry.
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,207 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistry_IDsAreStable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "registry.json")
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, added, err := r.Add("AAAA", "first", "magnet:?a", "/download")
+	if err != nil || !added {
+		t.Fatalf("Add() = %v, %v, %v", first, added, err)
+	}
+	second, _, _ := r.Add("bbbb", "second", "magnet:?b", "/download/tv")
+	again, added, _ := r.Add("aaaa", "dupe", "magnet:?a", "/elsewhere")
+	if added || again.ID != first.ID || again.DownloadDir != "/download" {
+		t.Errorf("Add() of existing hash = %v, %v, want %v", again, added, first)
+	}
+	if err := r.Update("bbbb", func(e *Entry) { e.TransferID = 42 }); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove("aaaa"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		lookup func() (Entry, bool)
+		wantID int64
+		wantOK bool
+	}{
+		{"By hash", func() (Entry, bool) { return reopened.ByHash("BBBB") }, second.ID, true},
+		{"By ID", func() (Entry, bool) { return reopened.ByID(second.ID) }, second.ID, true},
+		{"By transfer ID", func() (Entry, bool) { return reopened.ByTransferID(42) }, second.ID, true},
+		{"Removed", func() (Entry, bool) { return reopened.ByHash("aaaa") }, 0, false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.lookup()
+			if ok != tt.wantOK || got.ID != tt.wantID {
+				t.Errorf("lookup = %v, %v, want ID %d, %v", got, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+
+	// IDs are never reused, even after the newest torrent is removed.
+	beforeRemove := time.Now().Add(-time.Second)
+	if err := reopened.Remove("bbbb"); err != nil {
+		t.Fatal(err)
+	}
+	if removed := reopened.RemovedSince(beforeRemove); len(removed) != 1 || removed[0] != second.ID {
+		t.Errorf("RemovedSince() = %v, want [%d]", removed, second.ID)
+	}
+	third, _, _ := reopened.Add("cccc", "third", "magnet:?c", "/download")
+	if third.ID <= second.ID {
+		t.Errorf("Add() after removal got ID %d, want more than %d", third.ID, second.ID)
+	}
+}
+
+func TestRegistry_RecentlyCompleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	r, err := Open(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, _, _ := r.Add("aaaa", "done", "magnet:?a", "/download")
+	r.Add("bbbb", "removed", "magnet:?b", "/download")
+	if err := r.Complete("AAAA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove("bbbb"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hash   string
+		wantID int64
+		wantOK bool
+	}{
+		{"Completed", "aaaa", done.ID, true},
+		{"Completed, upper case", "AAAA", done.ID, true},
+		{"Removed", "bbbb", 0, false},
+		{"Never added", "cccc", 0, false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := r.RecentlyCompleted(tt.hash)
+			if ok != tt.wantOK || (ok && got.ID != tt.wantID) {
+				t.Errorf("RecentlyCompleted(%s) = %v, %v, want ID %d, %v", tt.hash, got, ok, tt.wantID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestEntry_RenamedPath(t *testing.T) {
+	entry := Entry{Renames: []Rename{
+		{Path: "Show.S01", Name: "Show Season 1"},
+		{Path: "Show Season 1/e01.mkv", Name: "Pilot.mkv"},
+		{Path: "Show Season 1/Subs", Name: "Subtitles"},
+	}}
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"Root", "Show.S01", "Show Season 1"},
+		{"Renamed twice", "Show.S01/e01.mkv", "Show Season 1/Pilot.mkv"},
+		{"Under renamed directory", "Show.S01/Subs/e01.srt", "Show Season 1/Subtitles/e01.srt"},
+		{"Untouched sibling", "Show.S01/e02.mkv", "Show Season 1/e02.mkv"},
+		{"Prefix is not a parent", "Show.S01x/e01.mkv", "Show.S01x/e01.mkv"},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := entry.RenamedPath(tt.path); got != tt.want {
+				t.Errorf("RenamedPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntry_SetWanted(t *testing.T) {
+	var entry Entry
+	entry.SetWanted([]int{4, 1, 2}, false)
+	entry.SetWanted([]int{2, 7}, true)
+	if !reflect.DeepEqual(entry.Unwanted, []int{1, 4}) {
+		t.Errorf("Unwanted = %v, want [1 4]", entry.Unwanted)
+	}
+	for index, want := range []bool{true, false, true, true, false, true} {
+		if got := entry.Wanted(index); got != want {
+			t.Errorf("Wanted(%d) = %v, want %v", index, got, want)
+		}
+	}
+}
+
+func TestEntry_SetPriority(t *testing.T) {
+	var entry Entry
+	entry.SetPriority([]int{0, 3}, 1)
+	entry.SetPriority([]int{1}, -1)
+	entry.SetPriority([]int{3}, 0)
+	for index, want := range []int64{1, -1, 0, 0} {
+		if got := entry.Priority(index); got != want {
+			t.Errorf("Priority(%d) = %v, want %v", index, got, want)
+		}
+	}
+	if len(entry.Priorities) != 2 {
+		t.Errorf("Priorities = %v, want only non-normal priorities", entry.Priorities)
+	}
+}
+
+func Test_moveInQueue(t *testing.T) {
+	tests := []struct {
+		name     string
+		selected []string
+		move     string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "Top", selected: []string{"c", "e"}, move: "top", want: []string{"c", "e", "a", "b", "d"}},
+		{name: "Bottom", selected: []string{"a", "c"}, move: "bottom", want: []string{"b", "d", "e", "a", "c"}},
+		{name: "Up", selected: []string{"b", "c", "e"}, move: "up", want: []string{"b", "c", "a", "e", "d"}},
+		{name: "Up from top", selected: []string{"a"}, move: "up", want: []string{"a", "b", "c", "d", "e"}},
+		{name: "Down", selected: []string{"a", "d"}, move: "down", want: []string{"b", "a", "c", "e", "d"}},
+		{name: "Unknown", selected: []string{"a"}, move: "sideways", wantErr: true},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			selected := make(map[string]bool)
+			for _, hash := range tt.selected {
+				selected[hash] = true
+			}
+			got, err := moveInQueue([]string{"a", "b", "c", "d", "e"}, selected, tt.move)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("moveInQueue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("moveInQueue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
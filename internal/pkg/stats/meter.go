@@ -0,0 +1,41 @@
+package stats
+
+import "time"
+
+// Seconds of history a Meter averages over.
+const meterWindow = 5
+
+// Meter measures a transfer rate over the last few seconds.  It isn't safe
+// for concurrent use.
+type Meter struct {
+	buckets [meterWindow]int64
+	last    int64 // Unix second of the newest bucket.
+}
+
+func (m *Meter) Add(n int64) {
+	m.advance(time.Now().Unix())
+	m.buckets[m.last%meterWindow] += n
+}
+
+// Rate returns bytes per second, averaged over the window.
+func (m *Meter) Rate() int64 {
+	m.advance(time.Now().Unix())
+	var total int64
+	for _, n := range m.buckets {
+		total += n
+	}
+	return total / meterWindow
+}
+
+func (m *Meter) advance(now int64) {
+	if now-m.last >= meterWindow {
+		m.buckets = [meterWindow]int64{}
+	} else {
+		for second := m.last + 1; second <= now; second++ {
+			m.buckets[second%meterWindow] = 0
+		}
+	}
+	if now > m.last {
+		m.last = now
+	}
+}
@@ -0,0 +1,130 @@
+// Package stats keeps transfer statistics for the current session and, saved
+// between restarts, cumulatively.
+package stats
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Counters are Transmission's session statistics.  Files are counted as
+// added once their torrent's local download starts, when put.io has listed them.
+type Counters struct {
+	UploadedBytes     int64 `json:"uploadedBytes"`
+	DownloadedBytes   int64 `json:"downloadedBytes"`
+	FilesAdded        int64 `json:"filesAdded"`
+	TorrentsCompleted int64 `json:"torrentsCompleted"`
+	SessionCount      int64 `json:"sessionCount"`
+	SecondsActive     int64 `json:"secondsActive"`
+}
+
+func (c Counters) plus(o Counters) Counters {
+	return Counters{
+		UploadedBytes:     c.UploadedBytes + o.UploadedBytes,
+		DownloadedBytes:   c.DownloadedBytes + o.DownloadedBytes,
+		FilesAdded:        c.FilesAdded + o.FilesAdded,
+		TorrentsCompleted: c.TorrentsCompleted + o.TorrentsCompleted,
+		SessionCount:      c.SessionCount + o.SessionCount,
+		SecondsActive:     c.SecondsActive + o.SecondsActive,
+	}
+}
+
+type Stats struct {
+	saveMu   sync.Mutex
+	mu       sync.Mutex
+	path     string
+	started  time.Time
+	current  Counters
+	previous Counters // Cumulative, as of the start of this session.
+	rate     Meter
+}
+
+// Open loads the cumulative statistics saved at path and starts a new session.
+func Open(path string) (*Stats, error) {
+	s := &Stats{path: path, started: time.Now(), current: Counters{SessionCount: 1}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.previous); err != nil {
+			return nil, err
+		}
+	}
+	return s, s.Save()
+}
+
+func (s *Stats) FilesAdded(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.FilesAdded += int64(n)
+}
+
+func (s *Stats) TorrentCompleted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current.TorrentsCompleted++
+}
+
+// DownloadCounter returns a writer which counts the bytes written to it as downloaded.
+func (s *Stats) DownloadCounter() io.Writer {
+	return downloadCounter{s}
+}
+
+type downloadCounter struct{ s *Stats }
+
+func (d downloadCounter) Write(p []byte) (int, error) {
+	d.s.mu.Lock()
+	defer d.s.mu.Unlock()
+	d.s.current.DownloadedBytes += int64(len(p))
+	d.s.rate.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// DownloadRate is the overall local download speed, in bytes per second.
+func (s *Stats) DownloadRate() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rate.Rate()
+}
+
+func (s *Stats) Current() Counters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.currentLocked()
+}
+
+func (s *Stats) currentLocked() Counters {
+	current := s.current
+	current.SecondsActive = int64(time.Since(s.started).Seconds())
+	return current
+}
+
+func (s *Stats) Cumulative() Counters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.previous.plus(s.currentLocked())
+}
+
+// Save writes out the cumulative statistics.
+func (s *Stats) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	data, err := json.MarshalIndent(s.Cumulative(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0777); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
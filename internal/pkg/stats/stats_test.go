@@ -0,0 +1,70 @@
+package stats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStats_CumulativeAcrossSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.json")
+
+	first, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.FilesAdded(3)
+	if _, err := first.DownloadCounter().Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	first.TorrentCompleted()
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.FilesAdded(1)
+	current, cumulative := second.Current(), second.Cumulative()
+	tests := []struct {
+		name string
+		got  int64
+		want int64
+	}{
+		{"Current sessions", current.SessionCount, 1},
+		{"Current files added", current.FilesAdded, 1},
+		{"Current downloaded", current.DownloadedBytes, 0},
+		{"Cumulative sessions", cumulative.SessionCount, 2},
+		{"Cumulative files added", cumulative.FilesAdded, 4},
+		{"Cumulative downloaded", cumulative.DownloadedBytes, 100},
+		{"Cumulative completed", cumulative.TorrentsCompleted, 1},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %d, want %d", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeter_Rate(t *testing.T) {
+	var m Meter
+	m.Add(meterWindow * 1000)
+	if got := m.Rate(); got != 1000 {
+		t.Errorf("Rate() = %d, want 1000", got)
+	}
+	m.advance(m.last + meterWindow)
+	if got := m.Rate(); got != 0 {
+		t.Errorf("Rate() after window = %d, want 0", got)
+	}
+}
@@ -0,0 +1,59 @@
+package torrent
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+// Transmission's error types, as reported in torrent-get's error field.
+const (
+	ErrorNone           int64 = 0
+	ErrorTrackerWarning int64 = 1
+	ErrorTrackerError   int64 = 2
+	ErrorLocal          int64 = 3
+)
+
+// putIoError is a failure on put.io's side, which Transmission clients
+// understand best as a tracker error.
+type putIoError struct {
+	message string
+}
+
+func (e putIoError) Error() string {
+	return e.message
+}
+
+// errorFields maps a failed fetch to Transmission's error and errorString.
+func errorFields(err error) (int64, string) {
+	if e, ok := err.(putIoError); ok {
+		return ErrorTrackerError, e.message
+	}
+	if isNoSpace(err) {
+		return ErrorLocal, "No space left on device"
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return ErrorLocal, "Timed out downloading from put.io: " + err.Error()
+	}
+	return ErrorLocal, "Local download failed: " + err.Error()
+}
+
+// isNotFound reports whether put.io no longer has what was asked for.  go-putio
+// answers a 404 with its sentinel, rather than an ErrorResponse.
+func isNotFound(err error) bool {
+	return err == putio.ErrResourceNotFound
+}
+
+func isNoSpace(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return err == syscall.ENOSPC
+}
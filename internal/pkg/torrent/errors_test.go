@@ -0,0 +1,61 @@
+package torrent
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func Test_errorFields(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantCode   int64
+		wantString string
+	}{
+		{"put.io", putIoError{"No peers"}, ErrorTrackerError, "No peers"},
+		{"Disk full", &os.PathError{Op: "write", Path: "/download/x", Err: syscall.ENOSPC}, ErrorLocal, "No space left on device"},
+		{"Timeout", timeoutError{}, ErrorLocal, "Timed out downloading from put.io: i/o timeout"},
+		{"Other", errors.New("unexpected EOF"), ErrorLocal, "Local download failed: unexpected EOF"},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			code, message := errorFields(tt.err)
+			if code != tt.wantCode || message != tt.wantString {
+				t.Errorf("errorFields() = %v, %q, want %v, %q", code, message, tt.wantCode, tt.wantString)
+			}
+		})
+	}
+}
+
+func Test_isNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Not found", putio.ErrResourceNotFound, true},
+		{"Other put.io error", putio.ErrPaymentRequired, false},
+		{"Other status", &putio.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}, false},
+		{"Other error", errors.New("EOF"), false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNotFound(tt.err); got != tt.want {
+				t.Errorf("isNotFound() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
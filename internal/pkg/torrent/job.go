@@ -0,0 +1,240 @@
+package torrent
+
+import (
+	"context"
+	"sync"
+
+	"github.com/anonfunc/transmissio/internal/pkg/stats"
+)
+
+// Phase is how far a torrent has got on its way to the download directory.
+type Phase int
+
+const (
+	PhaseQueued  Phase = iota // Waiting to be submitted to put.io.
+	PhasePutIo                // Being downloaded by put.io.
+	PhaseWaiting              // In the local download queue.
+	PhaseLocal                // Being downloaded from put.io.
+	PhaseDone                 // Downloaded locally.
+)
+
+// Progress is the state of a torrent's job.
+type Progress struct {
+	Phase      Phase
+	LocalBytes int64 // Downloaded locally, including earlier attempts.
+	LocalSize  int64 // Of the wanted files, once known.
+	LocalRate  int64 // Bytes per second.
+}
+
+// job is the in-flight work for a single put.io transfer, kept so it can be
+// paused, resumed, and stopped and cleaned up if the transfer is removed.
+type job struct {
+	hash   string
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu          sync.Mutex
+	downloadDir string
+	localPath   string
+	paused      bool
+	unpaused    chan struct{}      // Closed when a paused job resumes.
+	runCancel   context.CancelFunc // Interrupts the current run when paused.
+	runDone     chan struct{}      // Closed when the current run's work returns.
+	interrupted bool
+	phase       Phase
+	localBytes  int64
+	localSize   int64
+	localRate   stats.Meter
+}
+
+func (j *job) setPhase(phase Phase) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.phase = phase
+	if phase == PhaseLocal {
+		j.localBytes = 0
+	}
+}
+
+// addLocal counts bytes of the local copy, which only count towards the rate
+// when they have just been downloaded.
+func (j *job) addLocal(n int64, downloaded bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.localBytes += n
+	if downloaded {
+		j.localRate.Add(n)
+	}
+}
+
+func (j *job) setLocalSize(size int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.localSize = size
+}
+
+func (j *job) progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Progress{Phase: j.phase, LocalBytes: j.localBytes, LocalSize: j.localSize, LocalRate: j.localRate.Rate()}
+}
+
+// Write counts what is written as freshly downloaded.
+func (j *job) Write(p []byte) (int, error) {
+	j.addLocal(int64(len(p)), true)
+	return len(p), nil
+}
+
+func (j *job) setDownloadDir(downloadDir string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.downloadDir = downloadDir
+}
+
+func (j *job) getDownloadDir() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.downloadDir
+}
+
+func (j *job) setLocalPath(localPath string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.localPath = localPath
+}
+
+func (j *job) getLocalPath() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.localPath
+}
+
+func (j *job) pause() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.paused {
+		return
+	}
+	j.paused = true
+	j.unpaused = make(chan struct{})
+	if j.runCancel != nil {
+		j.runCancel()
+		j.interrupted = true
+	}
+}
+
+// pauseAndWait pauses the job and waits for any interrupted work to return,
+// so its files can be safely touched.
+func (j *job) pauseAndWait() {
+	j.pause()
+	j.mu.Lock()
+	runDone := j.runDone
+	j.mu.Unlock()
+	if runDone != nil {
+		<-runDone
+	}
+}
+
+func (j *job) resume() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if !j.paused {
+		return
+	}
+	j.paused = false
+	close(j.unpaused)
+}
+
+func (j *job) isPaused() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.paused
+}
+
+// run waits until the job isn't paused, then calls work with a context which
+// is cancelled if the job is paused or removed.  Work interrupted by a pause is
+// retried once the job resumes.
+func (j *job) run(work func(ctx context.Context) error) error {
+	for {
+		j.mu.Lock()
+		if j.paused {
+			unpaused := j.unpaused
+			j.mu.Unlock()
+			select {
+			case <-unpaused:
+			case <-j.ctx.Done():
+				return j.ctx.Err()
+			}
+			continue
+		}
+		runCtx, runCancel := context.WithCancel(j.ctx)
+		runDone := make(chan struct{})
+		j.runCancel = runCancel
+		j.runDone = runDone
+		j.interrupted = false
+		j.mu.Unlock()
+
+		err := work(runCtx)
+
+		j.mu.Lock()
+		j.runCancel = nil
+		j.runDone = nil
+		interrupted := j.interrupted
+		j.mu.Unlock()
+		runCancel()
+		close(runDone)
+		if err == nil || j.ctx.Err() != nil || !interrupted {
+			return err
+		}
+		// Interrupted by a pause, so go around and wait.
+	}
+}
+
+type jobs struct {
+	mu     sync.Mutex
+	byHash map[string]*job
+}
+
+func newJobs() *jobs {
+	return &jobs{byHash: make(map[string]*job)}
+}
+
+// start begins a job for hash, or returns nil if there is one already.
+func (js *jobs) start(hash string, downloadDir string, paused bool) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		hash:        hash,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		downloadDir: downloadDir,
+	}
+	if paused {
+		j.pause()
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if js.byHash[hash] != nil {
+		cancel()
+		return nil
+	}
+	js.byHash[hash] = j
+	return j
+}
+
+func (js *jobs) finish(j *job) {
+	js.mu.Lock()
+	if js.byHash[j.hash] == j {
+		delete(js.byHash, j.hash)
+	}
+	js.mu.Unlock()
+	j.cancel()
+	close(j.done)
+}
+
+func (js *jobs) get(hash string) *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.byHash[hash]
+}
@@ -0,0 +1,62 @@
+package torrent
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_job_run_pauseAndResume(t *testing.T) {
+	js := newJobs()
+	j := js.start("hash", "/download", false)
+	defer js.finish(j)
+	if js.start("hash", "/download", false) != nil {
+		t.Fatal("start() of a running hash should return nil")
+	}
+
+	calls := make(chan int, 10)
+	result := make(chan error)
+	attempt := 0
+	go func() {
+		result <- j.run(func(ctx context.Context) error {
+			attempt++
+			calls <- attempt
+			if attempt > 1 {
+				return nil
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+
+	<-calls
+	j.pause()
+	select {
+	case err := <-result:
+		t.Fatalf("run() returned %v while paused", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	j.resume()
+	if got := <-calls; got != 2 {
+		t.Errorf("work called %d times after resume, want 2", got)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("run() = %v, want nil", err)
+	}
+}
+
+func Test_job_run_removed(t *testing.T) {
+	js := newJobs()
+	j := js.start("hash", "/download", true)
+	result := make(chan error)
+	go func() {
+		result <- j.run(func(ctx context.Context) error {
+			t.Error("work should not run while paused")
+			return nil
+		})
+	}()
+	js.finish(j)
+	if err := <-result; err != context.Canceled {
+		t.Errorf("run() = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,80 @@
+package torrent
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/igungor/go-putio/putio"
+)
+
+// indexedFile is a put.io file with its index in the torrent's file list.
+type indexedFile struct {
+	index int
+	file  putio.File
+}
+
+// sortFiles orders files for downloading: by priority, highest first, then by
+// order, which is one of "listing" (put.io's), "smallest", "largest" or
+// "natural" (by name, with numbers compared by value, for episode order).
+func sortFiles(files []indexedFile, entry registry.Entry, order string) {
+	var less func(a, b indexedFile) bool
+	switch order {
+	case "smallest":
+		less = func(a, b indexedFile) bool { return a.file.Size < b.file.Size }
+	case "largest":
+		less = func(a, b indexedFile) bool { return a.file.Size > b.file.Size }
+	case "natural":
+		less = func(a, b indexedFile) bool { return naturalLess(a.file.Name, b.file.Name) }
+	default:
+		if order != "listing" {
+			log.Printf("Unknown download order %s, using listing", order)
+		}
+		less = func(a, b indexedFile) bool { return a.index < b.index }
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		pi, pj := entry.Priority(files[i].index), entry.Priority(files[j].index)
+		if pi != pj {
+			return pi > pj
+		}
+		return less(files[i], files[j])
+	})
+}
+
+// naturalLess compares strings ignoring case, with runs of digits compared
+// numerically, so "S01E2" comes before "S01E10".
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			na, nb := digitRun(a), digitRun(b)
+			ta, tb := strings.TrimLeft(a[:na], "0"), strings.TrimLeft(b[:nb], "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			a, b = a[na:], b[nb:]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func digitRun(s string) int {
+	n := 0
+	for n < len(s) && isDigit(s[n]) {
+		n++
+	}
+	return n
+}
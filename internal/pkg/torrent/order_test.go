@@ -0,0 +1,45 @@
+package torrent
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/igungor/go-putio/putio"
+)
+
+func Test_sortFiles(t *testing.T) {
+	listing := []indexedFile{
+		{0, putio.File{Name: "Show/Show.S01E10.mkv", Size: 300}},
+		{1, putio.File{Name: "Show/Show.S01E2.mkv", Size: 100}},
+		{2, putio.File{Name: "Show/show.s01e1.mkv", Size: 200}},
+		{3, putio.File{Name: "Show/Sample.mkv", Size: 10}},
+	}
+	tests := []struct {
+		name       string
+		order      string
+		priorities map[int]int64
+		want       []int
+	}{
+		{name: "Listing", order: "listing", want: []int{0, 1, 2, 3}},
+		{name: "Smallest", order: "smallest", want: []int{3, 1, 2, 0}},
+		{name: "Largest", order: "largest", want: []int{0, 2, 1, 3}},
+		{name: "Natural", order: "natural", want: []int{3, 2, 1, 0}},
+		{name: "Unknown", order: "random", want: []int{0, 1, 2, 3}},
+		{name: "Priorities first", order: "natural", priorities: map[int]int64{0: 1, 3: -1}, want: []int{0, 2, 1, 3}},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			files := append([]indexedFile(nil), listing...)
+			sortFiles(files, registry.Entry{Priorities: tt.priorities}, tt.order)
+			var got []int
+			for _, f := range files {
+				got = append(got, f.index)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
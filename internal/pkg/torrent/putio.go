@@ -2,18 +2,24 @@ package torrent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/anonfunc/transmissio/internal/pkg/stats"
 	"github.com/igungor/go-putio/putio"
-	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
 )
 
@@ -23,17 +29,54 @@ type FetchResult struct {
 	DownloadDir string
 }
 
+// ErrRemoved is the FetchResult error for transfers removed while in progress.
+var ErrRemoved = errors.New("transfer removed")
+
+// ErrDuplicate is returned when adding a torrent which is already registered.
+var ErrDuplicate = errors.New("duplicate torrent")
+
 type PutIoDownloader struct {
 	Client       *putio.Client
 	PendingLinks chan string
 	Results      chan FetchResult
+	Registry     *registry.Registry
+	Stats        *stats.Stats
+	jobs         *jobs
+	queue        *downloadQueue
+	snapshot     *snapshot
+}
+
+// AddOptions are the torrent-add settings kept with a torrent.
+type AddOptions struct {
+	Paused            bool // Not submitted to put.io until started.
+	Labels            []string
+	BandwidthPriority int64
+	Unwanted          []int // File indices not to download locally.
+	Priorities        map[int]int64
 }
 
-func (r PutIoDownloader) AsyncFetchMagnetLink(urlStr string, downloadDir string) {
+func (o AddOptions) apply(e *registry.Entry) {
+	e.Paused = o.Paused
+	e.Labels = o.Labels
+	e.BandwidthPriority = o.BandwidthPriority
+	e.SetWanted(o.Unwanted, false)
+	e.Priorities = o.Priorities
+}
+
+// AsyncFetchMagnetLink registers the magnet link and fetches it in the background.
+func (r PutIoDownloader) AsyncFetchMagnetLink(urlStr string, downloadDir string, options AddOptions) (registry.Entry, error) {
+	entry, added, err := r.Register(urlStr, downloadDir, options)
+	if err != nil {
+		return entry, err
+	}
+	if !added {
+		return entry, ErrDuplicate
+	}
 	go func() {
-		result, _ := r.FetchMagnetLink(urlStr, downloadDir)
+		result, _ := r.fetch(entry)
 		r.Results <- result
 	}()
+	return entry, nil
 }
 
 func (r PutIoDownloader) AsyncFetchMagnetFile(filename, downloadDir string) {
@@ -51,12 +94,35 @@ func (r PutIoDownloader) AsyncFetchTorrent(filename, downloadDir string) {
 }
 
 func NewDownloader() *PutIoDownloader {
-	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: viper.GetString("oauth_token")})
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: config.GetString("oauth_token")})
 	oauthClient := oauth2.NewClient(context.Background(), tokenSource)
+	reg, err := registry.Open(config.Path("registry"))
+	if err != nil {
+		log.Fatalf("Unable to open torrent registry: %s", err.Error())
+	}
+	sessionStats, err := stats.Open(config.Path("stats"))
+	if err != nil {
+		log.Fatalf("Unable to open session stats: %s", err.Error())
+	}
 	downloader := &PutIoDownloader{
-		Client:  putio.NewClient(oauthClient),
-		Results: make(chan FetchResult, 100),
+		Client:   putio.NewClient(oauthClient),
+		Results:  make(chan FetchResult, 100),
+		Registry: reg,
+		Stats:    sessionStats,
+		jobs:     newJobs(),
+		queue:    newDownloadQueue(reg),
+		snapshot: newSnapshot(),
 	}
+	go downloader.pollTransfers()
+	downloader.resume()
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		for range ticker.C {
+			if err := downloader.Stats.Save(); err != nil {
+				log.Printf("Unable to save session stats: %s", err.Error())
+			}
+		}
+	}()
 	go func() {
 		for {
 			result := <-downloader.Results
@@ -75,13 +141,16 @@ func (r PutIoDownloader) FetchMagnetFile(filename, downloadDir string) (FetchRes
 	if err != nil {
 		return FetchResult{Error: err}, err
 	}
-	result, err := r.FetchMagnetLink(string(magnetLinkBytes), downloadDir)
-	renameOriginal(err, filename)
-	return result, err
+	// Most tools end the file with a newline, which isn't part of the link.
+	return r.fetchFile(filename, strings.TrimSpace(string(magnetLinkBytes)), downloadDir)
 }
 
 func renameOriginal(err error, filename string) {
-	if err == nil {
+	if err == ErrDuplicate {
+		if err := os.Rename(filename, filename+".duplicate"); err != nil {
+			log.Printf("Unable to rename %s", filename)
+		}
+	} else if err == nil {
 		if err := os.Rename(filename, filename+".done"); err != nil {
 			log.Printf("Unable to rename %s", filename)
 		}
@@ -98,46 +167,573 @@ func (r PutIoDownloader) FetchTorrent(filename, downloadDir string) (FetchResult
 		err := fmt.Errorf("unable to fetch from torrent file %s", filename)
 		return FetchResult{Error: err}, err
 	}
-	result, err := r.FetchMagnetLink(magnetLink, downloadDir)
+	return r.fetchFile(filename, magnetLink, downloadDir)
+}
+
+// Register records the torrent behind a magnet link with the options it was
+// added with, returning its registry entry and whether it was newly added.
+// Torrents already on put.io are taken on, but not newly added.
+func (r PutIoDownloader) Register(magnetLink, downloadDir string, options AddOptions) (registry.Entry, bool, error) {
+	mi, err := metainfo.ParseMagnetURI(magnetLink)
+	if err != nil {
+		return registry.Entry{}, false, err
+	}
+	hash := mi.InfoHash.HexString()
+	if entry, ok := r.Registry.ByHash(hash); ok {
+		if entry.External {
+			// Asked for explicitly, so download it.
+			entry, err := r.adopt(entry, options)
+			return entry, false, err
+		}
+		return entry, false, nil
+	}
+	if entry, ok := r.Registry.RecentlyCompleted(hash); ok {
+		log.Printf("Already downloaded %s", entry.Name)
+		return entry, false, nil
+	}
+	transfer, err := r.findTransfer(hash)
+	if err != nil {
+		return registry.Entry{}, false, err
+	}
+	entry, added, err := r.Registry.Add(hash, mi.DisplayName, magnetLink, downloadDir)
+	if err != nil || !added {
+		return entry, false, err
+	}
+	if transfer == nil {
+		err := r.Registry.Update(hash, options.apply)
+		entry, _ = r.Registry.ByHash(hash)
+		return entry, true, err
+	}
+	log.Printf("Found existing put.io transfer %d for %s", transfer.ID, entry.Name)
+	r.updateEntry(hash, func(e *registry.Entry) {
+		e.TransferID = transfer.ID
+		if e.Name == "" {
+			e.Name = transfer.Name
+		}
+	})
+	entry, _ = r.Registry.ByHash(hash)
+	entry, err = r.adopt(entry, options)
+	return entry, false, err
+}
+
+// adopt takes on a torrent already on put.io with the options it was added
+// with, downloading it unless it was added paused.
+func (r PutIoDownloader) adopt(entry registry.Entry, options AddOptions) (registry.Entry, error) {
+	err := r.Registry.Update(entry.Hash, func(e *registry.Entry) {
+		options.apply(e)
+		e.External = false
+	})
+	if err != nil {
+		return entry, err
+	}
+	entry, _ = r.Registry.ByHash(entry.Hash)
+	if options.Paused {
+		return entry, nil
+	}
+	return entry, r.Start(entry)
+}
+
+// findTransfer looks for a put.io transfer of a magnet link with hash.
+func (r PutIoDownloader) findTransfer(hash string) (*putio.Transfer, error) {
+	transfers, err := r.Transfers()
+	if err != nil {
+		return nil, err
+	}
+	for i, transfer := range transfers {
+		if !strings.HasPrefix(transfer.Source, "magnet:") {
+			continue
+		}
+		mi, err := metainfo.ParseMagnetURI(transfer.Source)
+		if err == nil && strings.EqualFold(mi.InfoHash.HexString(), hash) {
+			return &transfers[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// fetchFile fetches the magnet link of a blackhole file.  The file is renamed
+// as soon as the torrent is registered, rather than after the download, so
+// later watcher events for it aren't taken for a duplicate.
+func (r PutIoDownloader) fetchFile(filename, magnetLink, downloadDir string) (FetchResult, error) {
+	entry, added, err := r.Register(magnetLink, downloadDir, AddOptions{})
+	if err == nil && !added {
+		err = ErrDuplicate
+	}
 	renameOriginal(err, filename)
+	if err != nil {
+		return FetchResult{Error: err, Name: entry.Name, DownloadDir: entry.DownloadDir}, err
+	}
+	return r.fetch(entry)
+}
+
+// resume restarts the jobs of torrents which were in progress at shutdown.
+func (r PutIoDownloader) resume() {
+	for _, entry := range r.Registry.Entries() {
+		if entry.External || entry.Source == "" || entry.Error != ErrorNone {
+			continue
+		}
+		log.Printf("Resuming %s", entry.Name)
+		go func(entry registry.Entry) {
+			result, err := r.fetch(entry)
+			if err != ErrDuplicate {
+				r.Results <- result
+			}
+		}(entry)
+	}
+}
+
+// fetch runs a registered torrent through each phase: submitting it to
+// put.io, waiting for the transfer to complete, downloading the result and
+// cleaning up.  Torrents which got part way before a restart pick up where
+// they left off.
+func (r PutIoDownloader) fetch(entry registry.Entry) (FetchResult, error) {
+	j := r.jobs.start(entry.Hash, entry.DownloadDir, entry.Paused)
+	if j == nil {
+		return FetchResult{Error: ErrDuplicate, Name: entry.Name, DownloadDir: entry.DownloadDir}, ErrDuplicate
+	}
+	defer r.jobs.finish(j)
+	result, err := r.fetchJob(j, entry)
+	if err != nil && j.ctx.Err() != nil {
+		return FetchResult{Error: ErrRemoved, Name: entry.Name, DownloadDir: j.getDownloadDir()}, ErrRemoved
+	}
+	if err != nil {
+		// Kept, stopped, until torrent-start retries it.
+		code, message := errorFields(err)
+		r.updateEntry(entry.Hash, func(e *registry.Entry) {
+			e.Error = code
+			e.ErrorString = message
+		})
+	}
 	return result, err
 }
 
-func (r PutIoDownloader) FetchMagnetLink(urlStr string, downloadDir string) (FetchResult, error) {
-	transfer, err := r.Client.Transfers.Add(context.TODO(), urlStr, -1, "")
+func (r PutIoDownloader) fetchJob(j *job, entry registry.Entry) (FetchResult, error) {
+	if entry.DoneDate.IsZero() {
+		transferID, err := r.submit(j, entry)
+		if err != nil {
+			return FetchResult{Error: err}, err
+		}
+		// Not in entry if just submitted, but needed to cancel the transfer.
+		entry.TransferID = transferID
+		j.setPhase(PhasePutIo)
+		updated, err := r.awaitTransfer(j, transferID)
+		if err != nil {
+			return FetchResult{Error: err}, err
+		}
+		r.updateEntry(entry.Hash, func(e *registry.Entry) { e.FileID = updated.FileID })
+		err = j.run(func(ctx context.Context) error {
+			j.setPhase(PhaseWaiting)
+			if err := r.queue.acquire(ctx, j.hash); err != nil {
+				return err
+			}
+			defer r.queue.release()
+			j.setPhase(PhaseLocal)
+			return r.downloadCompletedTorrent(ctx, j, updated, j.getDownloadDir())
+		})
+		if err != nil {
+			return FetchResult{Error: err}, err
+		}
+		entry.DoneDate = time.Now()
+		r.updateEntry(entry.Hash, func(e *registry.Entry) { e.DoneDate = entry.DoneDate })
+		r.Stats.TorrentCompleted()
+		if err := r.Client.Files.Delete(context.TODO(), updated.FileID); err != nil {
+			log.Printf("Unable to remove completed download! %s", updated.Name)
+		}
+	}
+	j.setPhase(PhaseDone)
+	result := FetchResult{Name: entry.Name, DownloadDir: j.getDownloadDir()}
+	log.Printf("Waiting until 10 minutes after completion before removing transfer %s ...", entry.Name)
+	select {
+	case <-time.After(time.Until(entry.DoneDate.Add(10 * time.Minute))):
+	case <-j.ctx.Done():
+		// Removed by the client, which has already cleaned up.
+		return result, nil
+	}
+	if err := r.Client.Transfers.Cancel(context.TODO(), entry.TransferID); err != nil {
+		log.Printf("Unable to clean transfer %d! %s, %s", entry.TransferID, entry.Name, err.Error())
+	} else {
+		r.snapshot.drop(entry.TransferID)
+	}
+	if err := r.Registry.Complete(entry.Hash); err != nil {
+		log.Printf("Unable to unregister %s: %s", entry.Hash, err.Error())
+	}
+	return result, nil
+}
+
+// submit adds the torrent to put.io, unless that was done already, and
+// returns the transfer ID.  Stopped torrents are held back until started.
+func (r PutIoDownloader) submit(j *job, entry registry.Entry) (int64, error) {
+	if entry.TransferID != 0 {
+		return entry.TransferID, nil
+	}
+	var transfer putio.Transfer
+	err := j.run(func(ctx context.Context) error {
+		var err error
+		transfer, err = r.Client.Transfers.Add(ctx, entry.Source, -1, "")
+		if err != nil && ctx.Err() == nil {
+			return putIoError{"Unable to add transfer to put.io: " + err.Error()}
+		}
+		return err
+	})
 	if err != nil {
-		return FetchResult{Error: err}, err
+		return 0, err
 	}
+	r.snapshot.put(transfer)
+	r.updateEntry(entry.Hash, func(e *registry.Entry) {
+		e.TransferID = transfer.ID
+		if e.Name == "" {
+			e.Name = transfer.Name
+		}
+	})
+	return transfer.ID, nil
+}
+
+// awaitTransfer waits until the transfer is complete, checking on it as the
+// background poller lists put.io's transfers.
+func (r PutIoDownloader) awaitTransfer(j *job, transferID int64) (putio.Transfer, error) {
 	startTime := time.Now()
+	updates := r.snapshot.watch(transferID)
+	defer r.snapshot.unwatch(transferID)
+	var updated putio.Transfer
 	for {
+		select {
+		case update := <-updates:
+			if update.err != nil {
+				return updated, update.err
+			}
+			updated = update.transfer
+		case <-j.ctx.Done():
+			return updated, j.ctx.Err()
+		}
+		// fmt.Printf("%v\n", updated)
+		if updated.Status == "COMPLETED" || updated.Status == "SEEDING" {
+			return updated, nil
+		}
+		if updated.Status == "ERROR" {
+			return updated, putIoError{TransferErrorMessage(updated)}
+		}
 		if time.Now().After(startTime.Add(24 * time.Hour)) {
 			// After 24 hours, bail.
-			err := fmt.Errorf("transfer for %s taking too long, cancelling", transfer.Name)
-			return FetchResult{Error: err}, err
+			return updated, putIoError{"put.io transfer timed out after 24 hours"}
 		}
-		updated, err := r.Client.Transfers.Get(context.TODO(), transfer.ID)
-		// fmt.Printf("%v\n", updated)
+		sleepFor := sleepTime(updated.EstimatedTime, updated.CreatedAt)
+		log.Printf("Checking %s again in %.0f seconds, or when its status changes ...", updated.Name, sleepFor.Seconds())
+		r.snapshot.delay(transferID, sleepFor)
+	}
+}
+
+// Progress reports how far along a torrent is, or false if it has no job and
+// hasn't been downloaded.
+func (r PutIoDownloader) Progress(hash string) (Progress, bool) {
+	if j := r.jobs.get(hash); j != nil {
+		return j.progress(), true
+	}
+	if entry, ok := r.Registry.ByHash(hash); ok && !entry.DoneDate.IsZero() {
+		return Progress{Phase: PhaseDone}, true
+	}
+	return Progress{}, false
+}
+
+// FilesChanged restarts a local download in progress, so it picks up changes
+// to which files are wanted and their priorities.  Files already downloaded are kept.
+func (r PutIoDownloader) FilesChanged(hash string) {
+	j := r.jobs.get(hash)
+	if j == nil || j.isPaused() || j.progress().Phase != PhaseLocal {
+		return
+	}
+	j.pause()
+	j.resume()
+}
+
+// QueueChanged starts queued local downloads if the queue was reordered, or
+// its size changed.
+func (r PutIoDownloader) QueueChanged() {
+	r.queue.changed()
+}
+
+// TransferErrorMessage is put.io's explanation of a failed transfer.
+func TransferErrorMessage(transfer putio.Transfer) string {
+	if transfer.ErrorMessage != "" {
+		return transfer.ErrorMessage
+	}
+	if transfer.StatusMessage != "" {
+		return transfer.StatusMessage
+	}
+	return "put.io transfer failed"
+}
+
+// Active reports whether transmissio is still working on the torrent with hash.
+func (r PutIoDownloader) Active(hash string) bool {
+	return r.jobs.get(hash) != nil
+}
+
+func (r PutIoDownloader) updateEntry(hash string, change func(*registry.Entry)) {
+	if err := r.Registry.Update(hash, change); err != nil {
+		log.Printf("Unable to update registry for %s: %s", hash, err.Error())
+	}
+}
+
+// Stop pauses a torrent.  It is held back from put.io if it hasn't been
+// submitted yet, and any local download is interrupted until Start.
+func (r PutIoDownloader) Stop(entry registry.Entry) error {
+	if err := r.Registry.Update(entry.Hash, func(e *registry.Entry) { e.Paused = true }); err != nil {
+		return err
+	}
+	if j := r.jobs.get(entry.Hash); j != nil {
+		j.pause()
+	}
+	return nil
+}
+
+// Start resumes a stopped torrent, retrying its put.io transfer if put.io gave
+// up on it.  Torrents with no running job, e.g. since a restart, get one.
+func (r PutIoDownloader) Start(entry registry.Entry) error {
+	if err := r.Registry.Update(entry.Hash, func(e *registry.Entry) {
+		e.Paused = false
+		e.External = false
+		e.Error = ErrorNone
+		e.ErrorString = ""
+	}); err != nil {
+		return err
+	}
+	entry.Paused = false
+	entry.External = false
+	if entry.TransferID != 0 {
+		transfer, err := r.Client.Transfers.Get(context.TODO(), entry.TransferID)
 		if err != nil {
-			return FetchResult{Error: err}, err
+			return err
 		}
-		if updated.Status == "COMPLETED" || updated.Status == "SEEDING" {
-			if err := r.downloadCompletedTorrent(updated, downloadDir); err != nil {
-				return FetchResult{Error: err}, err
+		if transfer.Status == "ERROR" {
+			log.Printf("Retrying errored transfer %d for %s", transfer.ID, transfer.Name)
+			if transfer, err = r.Client.Transfers.Retry(context.TODO(), transfer.ID); err != nil {
+				return err
 			}
-			if err := r.Client.Files.Delete(context.TODO(), updated.FileID); err != nil {
-				log.Printf("Unable to remove completed download! %s", updated.Name)
+		}
+		// As retried, so torrent-get doesn't show the old error until the next refresh.
+		r.snapshot.put(transfer)
+	}
+	if j := r.jobs.get(entry.Hash); j != nil {
+		j.resume()
+		return nil
+	}
+	if entry.Source == "" {
+		return nil
+	}
+	go func() {
+		result, err := r.fetch(entry)
+		if err != ErrDuplicate {
+			r.Results <- result
+		}
+	}()
+	return nil
+}
+
+// SetLocation changes where a torrent is downloaded to.  With move, anything
+// already downloaded is moved there too; a running download is interrupted
+// for the move and then carries on in the new location.  A completed torrent
+// whose data can't be found is left where it was.
+func (r PutIoDownloader) SetLocation(entry registry.Entry, location string, move bool) error {
+	j := r.jobs.get(entry.Hash)
+	if j != nil && !j.isPaused() {
+		j.pauseAndWait()
+		defer j.resume()
+	}
+	if latest, ok := r.Registry.ByHash(entry.Hash); ok {
+		entry = latest
+	}
+	var movedTo string
+	if move {
+		oldPath := entry.LocalPath
+		if j != nil && j.getLocalPath() != "" {
+			oldPath = j.getLocalPath()
+		}
+		_, err := os.Stat(oldPath)
+		switch {
+		case oldPath == "" && !entry.DoneDate.IsZero():
+			return fmt.Errorf("local data of %s not found", entry.Name)
+		case oldPath == "":
+			// Not downloaded yet, so nothing to move.
+		case err != nil && !entry.DoneDate.IsZero():
+			return fmt.Errorf("local data of %s not found: %s", entry.Name, err.Error())
+		default:
+			movedTo = filepath.Join(location, filepath.Base(oldPath))
+			if err == nil && oldPath != movedTo {
+				log.Printf("Moving %s to %s", oldPath, movedTo)
+				if err := moveLocal(oldPath, movedTo); err != nil {
+					return err
+				}
 			}
-			log.Printf("Sleeping 10 minutes before removing transfer %s ...", transfer.Name)
-			time.Sleep(10 * time.Minute)
-			if err := r.Client.Transfers.Cancel(context.TODO(), updated.ID); err != nil {
-				log.Printf("Unable to clean transfer %d! %s, %s", updated.ID, updated.Name, err.Error())
+			if j != nil && j.getLocalPath() != "" {
+				j.setLocalPath(movedTo)
 			}
-			return FetchResult{Error: err, Name: transfer.Name, DownloadDir: downloadDir}, nil
 		}
-		sleepFor := sleepTime(updated.EstimatedTime, updated.CreatedAt)
-		log.Printf("Sleeping %.0f seconds for %s ...", sleepFor.Seconds(), transfer.Name)
-		time.Sleep(sleepFor)
 	}
+	if j != nil {
+		j.setDownloadDir(location)
+	}
+	return r.Registry.Update(entry.Hash, func(e *registry.Entry) {
+		e.DownloadDir = location
+		if movedTo != "" {
+			e.LocalPath = movedTo
+		}
+	})
+}
+
+// RenamePath renames a file or directory within a torrent, given its current
+// slash separated path relative to the download directory.  The new name is
+// used for anything not yet downloaded, and applied on disk to anything which
+// has been.
+func (r PutIoDownloader) RenamePath(entry registry.Entry, torrentPath, name string) error {
+	torrentPath = path.Clean(torrentPath)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	if torrentPath == "." || torrentPath == ".." || strings.HasPrefix(torrentPath, "../") || path.IsAbs(torrentPath) {
+		return fmt.Errorf("invalid path %q", torrentPath)
+	}
+	switch {
+	case !entry.DoneDate.IsZero() && entry.LocalPath != "":
+		// put.io's copy is deleted once downloaded, so go by ours.
+		if err := checkLocalPath(entry, torrentPath); err != nil {
+			return err
+		}
+	case entry.FileID != 0 && entry.DoneDate.IsZero():
+		if err := r.checkTorrentPath(entry, torrentPath); err != nil {
+			return err
+		}
+	default:
+		if top := topLevelName(entry, entry.Name); top == "" || strings.SplitN(torrentPath, "/", 2)[0] != top {
+			// Until put.io has the files, at least stay within the torrent.
+			return fmt.Errorf("path %s not found in torrent", torrentPath)
+		}
+	}
+	j := r.jobs.get(entry.Hash)
+	if j != nil && !j.isPaused() {
+		j.pauseAndWait()
+		defer j.resume()
+	}
+	oldLocal := filepath.Join(entry.DownloadDir, filepath.FromSlash(torrentPath))
+	newLocal := filepath.Join(filepath.Dir(oldLocal), name)
+	if _, err := os.Stat(oldLocal); err == nil {
+		log.Printf("Renaming %s to %s", oldLocal, newLocal)
+		if err := os.Rename(oldLocal, newLocal); err != nil {
+			return err
+		}
+	}
+	if j != nil && j.getLocalPath() == oldLocal {
+		j.setLocalPath(newLocal)
+	}
+	return r.Registry.Update(entry.Hash, func(e *registry.Entry) {
+		e.Renames = append(e.Renames, registry.Rename{Path: torrentPath, Name: name})
+		if e.LocalPath == oldLocal {
+			e.LocalPath = newLocal
+		}
+	})
+}
+
+// checkTorrentPath fails unless torrentPath names a file or directory in the torrent.
+func (r PutIoDownloader) checkTorrentPath(entry registry.Entry, torrentPath string) error {
+	files, err := r.TransferFiles(entry.FileID)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		p := entry.RenamedPath(filepath.ToSlash(file.Name))
+		if p == torrentPath || strings.HasPrefix(p, torrentPath+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("path %s not found in torrent", torrentPath)
+}
+
+// checkLocalPath fails unless torrentPath names a downloaded file or
+// directory in the torrent's local copy.
+func checkLocalPath(entry registry.Entry, torrentPath string) error {
+	local := filepath.Join(entry.DownloadDir, filepath.FromSlash(torrentPath))
+	if local != entry.LocalPath && !withinDir(entry.LocalPath, local) {
+		return fmt.Errorf("path %s not found in torrent", torrentPath)
+	}
+	if _, err := os.Stat(local); err != nil {
+		return fmt.Errorf("path %s not found in torrent", torrentPath)
+	}
+	return nil
+}
+
+// topLevelName is the torrent's top level file or directory name, after
+// renames, or "" unless that is a single path element.  Names come from
+// magnet links and put.io, so can't be trusted.
+func topLevelName(entry registry.Entry, name string) string {
+	name = entry.RenamedPath(name)
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		return ""
+	}
+	return name
+}
+
+// withinDir reports whether p is strictly inside dir.
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// FreeSpace returns how much of the put.io account's disk quota is left.
+func (r PutIoDownloader) FreeSpace() (int64, error) {
+	info, err := r.Client.Account.Info(context.TODO())
+	if err != nil {
+		return 0, err
+	}
+	return info.Disk.Avail, nil
+}
+
+// Paused reports whether the torrent with hash is stopped.
+func (r PutIoDownloader) Paused(hash string) bool {
+	if j := r.jobs.get(hash); j != nil {
+		return j.isPaused()
+	}
+	entry, _ := r.Registry.ByHash(hash)
+	return entry.Paused
+}
+
+// Remove cancels the put.io transfer, deletes its put.io file and stops any
+// local download still running for it.  With deleteLocalData, the local copy
+// is deleted as well.
+func (r PutIoDownloader) Remove(entry registry.Entry, deleteLocalData bool) error {
+	var localPath string
+	if j := r.jobs.get(entry.Hash); j != nil {
+		j.cancel()
+		<-j.done
+		localPath = j.getLocalPath()
+	}
+	// The job may have submitted the transfer or recorded its file since the
+	// caller looked the entry up.
+	if latest, ok := r.Registry.ByHash(entry.Hash); ok {
+		entry = latest
+	}
+	if deleteLocalData && localPath == "" {
+		// Only what was recorded as downloaded, so never the data of torrents
+		// added on put.io, or which hadn't got to their local download.
+		localPath = entry.LocalPath
+	}
+	if entry.TransferID != 0 {
+		err := r.Client.Transfers.Cancel(context.TODO(), entry.TransferID)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		r.snapshot.drop(entry.TransferID)
+	}
+	if entry.FileID != 0 {
+		if err := r.Client.Files.Delete(context.TODO(), entry.FileID); err != nil {
+			// Already gone if the local download finished.
+			log.Printf("Unable to remove put.io file %d for %s: %s", entry.FileID, entry.Name, err.Error())
+		}
+	}
+	if deleteLocalData && localPath != "" {
+		log.Printf("Deleting local data %s", localPath)
+		if err := os.RemoveAll(localPath); err != nil {
+			return err
+		}
+	}
+	return r.Registry.Remove(entry.Hash)
 }
 
 func sleepTime(remaining int64, createdAt *putio.Time) time.Duration {
@@ -164,35 +760,59 @@ func sleepTime(remaining int64, createdAt *putio.Time) time.Duration {
 	return time.Duration(fifth+rand.Int63n(30)) * time.Second
 }
 
-func (r PutIoDownloader) downloadCompletedTorrent(updated putio.Transfer, downloadDir string) error {
+func (r PutIoDownloader) downloadCompletedTorrent(ctx context.Context, j *job, updated putio.Transfer, downloadDir string) error {
 	log.Printf("Starting download of %s to %s", updated.Name, downloadDir)
-	file, err := r.Client.Files.Get(context.TODO(), updated.FileID)
+	file, err := r.Client.Files.Get(ctx, updated.FileID)
 	if err != nil {
 		return err
 	}
-	if err := r.recursiveDownload(file, downloadDir); err != nil {
+	var files []putio.File
+	if err := r.recursiveListFile(ctx, file, "", &files); err != nil {
 		return err
 	}
-	return nil
-}
-
-func (r PutIoDownloader) recursiveDownload(file putio.File, downloadDir string) error {
-	if file.ContentType == "application/x-directory" {
-		children, _, err := r.Client.Files.List(context.TODO(), file.ID)
-		if err != nil {
-			return err
+	// Renames, wanted files and priorities may change between runs, so pick up the latest.
+	entry, _ := r.Registry.ByHash(j.hash)
+	if entry.LocalPath == "" {
+		// The first time round, not on resuming.
+		r.Stats.FilesAdded(len(files))
+	}
+	if top := localPath(entry, downloadDir, file.Name); withinDir(downloadDir, top) {
+		r.recordLocalPath(j, top)
+	}
+	var wanted []indexedFile
+	var wantedSize int64
+	for i, f := range files {
+		if !entry.Wanted(i) {
+			log.Printf("Skipping unwanted %s", f.Name)
+			continue
 		}
-		for _, child := range children {
-			if err := r.recursiveDownload(child, filepath.Join(downloadDir, file.Name)); err != nil {
-				return err
-			}
+		wanted = append(wanted, indexedFile{i, f})
+		wantedSize += f.Size
+	}
+	j.setLocalSize(wantedSize)
+	sortFiles(wanted, entry, config.GetString("downloadOrder"))
+	for _, f := range wanted {
+		if err := r.downloadFile(ctx, j, f.file, localPath(entry, downloadDir, filepath.ToSlash(f.file.Name))); err != nil {
+			return err
 		}
-	} else if err := r.downloadFile(file, downloadDir); err != nil {
-		return err
 	}
 	return nil
 }
 
+// recordLocalPath notes where the job's torrent is downloaded to, in the
+// registry as well, so it can be found after a restart.
+func (r PutIoDownloader) recordLocalPath(j *job, localPath string) {
+	j.setLocalPath(localPath)
+	r.updateEntry(j.hash, func(e *registry.Entry) { e.LocalPath = localPath })
+}
+
+// localPath is where a file or directory within a torrent, named by its
+// put.io path, is downloaded to.
+func localPath(entry registry.Entry, downloadDir, torrentPath string) string {
+	return filepath.Join(downloadDir, filepath.FromSlash(entry.RenamedPath(torrentPath)))
+}
+
+// RecursiveList lists the files, but not directories, under a put.io file.
 func (r PutIoDownloader) RecursiveList(fileID int64, downloadDir string) ([]putio.File, error) {
 	var result []putio.File
 	file, err := r.Client.Files.Get(context.TODO(), fileID)
@@ -217,6 +837,7 @@ func (r PutIoDownloader) recursiveListFile(ctx context.Context,
 				return err
 			}
 		}
+		return nil
 	}
 	// Make File name the actual path
 	file.Name = filepath.Join(dir, file.Name)
@@ -224,25 +845,45 @@ func (r PutIoDownloader) recursiveListFile(ctx context.Context,
 	return nil
 }
 
-func (r PutIoDownloader) downloadFile(file putio.File, downloadDir string) error {
-	readCloser, err := r.Client.Files.Download(context.TODO(), file.ID, true, nil)
-	if err != nil {
+// downloadFile downloads a put.io file to downloadFilename, picking up where
+// it left off if an earlier attempt was interrupted.
+func (r PutIoDownloader) downloadFile(ctx context.Context, j *job, file putio.File, downloadFilename string) error {
+	if err := os.MkdirAll(filepath.Dir(downloadFilename), 0777); err != nil {
 		return err
 	}
-	if err := os.MkdirAll(downloadDir, 0777); err != nil {
+	var offset int64
+	if info, err := os.Stat(downloadFilename); err == nil && info.Size() <= file.Size {
+		offset = info.Size()
+	}
+	j.addLocal(offset, false)
+	if offset == file.Size && offset > 0 {
+		log.Printf("Already have %s", downloadFilename)
+		return nil
+	}
+	var headers http.Header
+	if offset > 0 {
+		log.Printf("Resuming download of %s at %d bytes", file.Name, offset)
+		headers = http.Header{"Range": []string{fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	readCloser, err := r.Client.Files.Download(ctx, file.ID, true, headers)
+	if err != nil {
 		return err
 	}
-	downloadFilename := filepath.Join(downloadDir, file.Name)
-	outFile, err := os.Create(downloadFilename)
+	defer readCloser.Close()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	outFile, err := os.OpenFile(downloadFilename, flags, 0666)
 	if err != nil {
 		return err
 	}
 	defer outFile.Close()
-	_, err = io.Copy(outFile, readCloser)
+	_, err = io.Copy(outFile, io.TeeReader(readCloser, io.MultiWriter(r.Stats.DownloadCounter(), j)))
 	if err != nil {
 		return err
 	}
-	log.Printf("Done with download of %s to %s", file.Name, downloadDir)
+	log.Printf("Done with download of %s", downloadFilename)
 	return nil
 }
 
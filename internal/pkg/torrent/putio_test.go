@@ -0,0 +1,339 @@
+package torrent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+)
+
+// newTestDownloader is a downloader with a registry in a temporary directory,
+// which also holds the download directory.  It makes no put.io calls as long
+// as entries have no transfer or file ID.
+func newTestDownloader(t *testing.T) (PutIoDownloader, string, func()) {
+	dir, err := ioutil.TempDir("", "putio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := registry.Open(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	r := PutIoDownloader{Registry: reg, jobs: newJobs(), snapshot: newSnapshot()}
+	downloadDir := filepath.Join(dir, "download")
+	if err := os.MkdirAll(downloadDir, 0777); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return r, downloadDir, func() { os.RemoveAll(dir) }
+}
+
+func writeFile(t *testing.T, name string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func TestPutIoDownloader_Remove(t *testing.T) {
+	tests := []struct {
+		name            string
+		entryName       string
+		external        bool
+		recorded        string // Within the download directory, as the entry's local path.
+		running         bool
+		localPath       string // Within the download directory, for a running job.
+		deleteLocalData bool
+		wantDeleted     bool
+	}{
+		{name: "Running job, delete local data", running: true, localPath: "Show", deleteLocalData: true, wantDeleted: true},
+		{name: "Running job, keep local data", running: true, localPath: "Show", wantDeleted: false},
+		{name: "Running job without local path", running: true, deleteLocalData: true, wantDeleted: false},
+		{name: "No job, recorded", recorded: "Show", deleteLocalData: true, wantDeleted: true},
+		{name: "No job, recorded, keep local data", recorded: "Show", wantDeleted: false},
+		{name: "No job, not recorded", entryName: "Show", deleteLocalData: true, wantDeleted: false},
+		{name: "External, not recorded", entryName: "Show", external: true, deleteLocalData: true, wantDeleted: false},
+		{name: "Running job without local path, not recorded", entryName: "Show", running: true,
+			deleteLocalData: true, wantDeleted: false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			r, downloadDir, cleanup := newTestDownloader(t)
+			defer cleanup()
+			entry, _, err := r.Registry.Add("aaaa", tt.entryName, "magnet:?a", downloadDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.Registry.Update(entry.Hash, func(e *registry.Entry) {
+				e.External = tt.external
+				if tt.recorded != "" {
+					e.LocalPath = filepath.Join(downloadDir, tt.recorded)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(downloadDir, "Show", "episode.mkv")
+			writeFile(t, file)
+			var j *job
+			if tt.running {
+				j = r.jobs.start(entry.Hash, downloadDir, false)
+				if tt.localPath != "" {
+					j.setLocalPath(filepath.Join(downloadDir, tt.localPath))
+				}
+				go func() {
+					<-j.ctx.Done()
+					r.jobs.finish(j)
+				}()
+			}
+
+			if err := r.Remove(entry, tt.deleteLocalData); err != nil {
+				t.Fatalf("Remove() error = %v", err)
+			}
+			if j != nil && j.ctx.Err() == nil {
+				t.Error("Remove() left the job running")
+			}
+			if _, ok := r.Registry.ByHash(entry.Hash); ok {
+				t.Error("Remove() left the torrent registered")
+			}
+			if deleted := !exists(file); deleted != tt.wantDeleted {
+				t.Errorf("local data deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if !exists(downloadDir) {
+				t.Error("Remove() deleted the download directory")
+			}
+		})
+	}
+}
+
+func Test_withinDir(t *testing.T) {
+	tests := []struct {
+		name string
+		p    string
+		want bool
+	}{
+		{"Inside", "/download/Show", true},
+		{"Nested", "/download/Show/episode.mkv", true},
+		{"Dots in name", "/download/..Show", true},
+		{"The directory", "/download", false},
+		{"Parent", "/", false},
+		{"Sibling", "/downloads", false},
+		{"Escaping", "/download/../etc", false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withinDir("/download", filepath.FromSlash(tt.p)); got != tt.want {
+				t.Errorf("withinDir(%s) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPutIoDownloader_RenamePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		renames  []registry.Rename
+		done     bool // Downloaded, and deleted from put.io.
+		path     string
+		newName  string
+		wantErr  bool
+		wantFile string // Within the download directory, afterwards.
+	}{
+		{name: "Torrent directory", path: "Show", newName: "Renamed", wantFile: "Renamed/episode.mkv"},
+		{name: "File", path: "Show/episode.mkv", newName: "e01.mkv", wantFile: "Show/e01.mkv"},
+		{name: "Renamed before", renames: []registry.Rename{{Path: "Show", Name: "Renamed"}},
+			path: "Renamed/episode.mkv", newName: "e01.mkv", wantFile: "Renamed/e01.mkv"},
+		{name: "Old name after renaming", renames: []registry.Rename{{Path: "Show", Name: "Renamed"}},
+			path: "Show/episode.mkv", newName: "e01.mkv", wantErr: true},
+		{name: "Parent", path: "..", newName: "x", wantErr: true},
+		{name: "Parent, not clean", path: "a/../..", newName: "x", wantErr: true},
+		{name: "Escaping", path: "../download/Show", newName: "x", wantErr: true},
+		{name: "Download directory", path: ".", newName: "x", wantErr: true},
+		{name: "Absolute", path: "/download/Show", newName: "x", wantErr: true},
+		{name: "Another torrent", path: "Other", newName: "x", wantErr: true},
+		{name: "Another torrent's file", path: "Other/movie.mkv", newName: "x", wantErr: true},
+		{name: "Name with separator", path: "Show", newName: "a/b", wantErr: true},
+		{name: "Name is parent", path: "Show", newName: "..", wantErr: true},
+		{name: "Completed, torrent directory", done: true, path: "Show", newName: "Renamed", wantFile: "Renamed/episode.mkv"},
+		{name: "Completed, file", done: true, path: "Show/episode.mkv", newName: "e01.mkv", wantFile: "Show/e01.mkv"},
+		{name: "Completed, renamed before", done: true, renames: []registry.Rename{{Path: "Show", Name: "Renamed"}},
+			path: "Renamed/episode.mkv", newName: "e01.mkv", wantFile: "Renamed/e01.mkv"},
+		{name: "Completed, missing file", done: true, path: "Show/e02.mkv", newName: "x", wantErr: true},
+		{name: "Completed, another torrent's file", done: true, path: "Other/movie.mkv", newName: "x", wantErr: true},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			r, downloadDir, cleanup := newTestDownloader(t)
+			defer cleanup()
+			entry, _, err := r.Registry.Add("aaaa", "Show", "magnet:?a", downloadDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry.Renames = tt.renames
+			top := entry.RenamedPath("Show")
+			if tt.done {
+				// There is no put.io client, so only the local copy can be checked.
+				entry.FileID = 10
+				entry.DoneDate = time.Now()
+				entry.LocalPath = filepath.Join(downloadDir, top)
+			}
+			writeFile(t, filepath.Join(downloadDir, top, "episode.mkv"))
+			writeFile(t, filepath.Join(downloadDir, "Other", "movie.mkv"))
+
+			err = r.RenamePath(entry, tt.path, tt.newName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RenamePath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				for _, name := range []string{filepath.Join(top, "episode.mkv"), "Other/movie.mkv"} {
+					if !exists(filepath.Join(downloadDir, filepath.FromSlash(name))) {
+						t.Errorf("failed RenamePath() moved %s", name)
+					}
+				}
+				return
+			}
+			if !exists(filepath.Join(downloadDir, filepath.FromSlash(tt.wantFile))) {
+				t.Errorf("RenamePath() didn't make %s", tt.wantFile)
+			}
+		})
+	}
+}
+
+func TestPutIoDownloader_SetLocation(t *testing.T) {
+	tests := []struct {
+		name         string
+		recorded     string // Within the download directory, as the entry's local path.
+		done         bool
+		move         bool
+		wantErr      bool
+		wantMoved    bool
+		wantRecorded string // Within the new location, afterwards.
+	}{
+		{name: "Move recorded", recorded: "Show", done: true, move: true, wantMoved: true, wantRecorded: "Show"},
+		{name: "Don't move", recorded: "Show", done: true, wantRecorded: ""},
+		{name: "Recorded data gone", recorded: "Gone", done: true, move: true, wantErr: true},
+		{name: "Done, not recorded", done: true, move: true, wantErr: true},
+		{name: "Not downloaded yet", move: true},
+		{name: "Recorded, nothing downloaded yet", recorded: "Gone", move: true, wantRecorded: "Gone"},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			r, downloadDir, cleanup := newTestDownloader(t)
+			defer cleanup()
+			entry, _, err := r.Registry.Add("aaaa", "Show", "magnet:?a", downloadDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.Registry.Update(entry.Hash, func(e *registry.Entry) {
+				if tt.recorded != "" {
+					e.LocalPath = filepath.Join(downloadDir, tt.recorded)
+				}
+				if tt.done {
+					e.DoneDate = time.Now()
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFile(t, filepath.Join(downloadDir, "Show", "episode.mkv"))
+			location := filepath.Join(filepath.Dir(downloadDir), "moved")
+
+			err = r.SetLocation(entry, location, tt.move)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetLocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			updated, _ := r.Registry.ByHash(entry.Hash)
+			wantDir := location
+			if tt.wantErr {
+				wantDir = downloadDir
+			}
+			if updated.DownloadDir != wantDir {
+				t.Errorf("DownloadDir = %s, want %s", updated.DownloadDir, wantDir)
+			}
+			if moved := exists(filepath.Join(location, "Show", "episode.mkv")); moved != tt.wantMoved {
+				t.Errorf("moved = %v, want %v", moved, tt.wantMoved)
+			}
+			if tt.wantRecorded != "" && updated.LocalPath != filepath.Join(location, tt.wantRecorded) {
+				t.Errorf("LocalPath = %s, want %s", updated.LocalPath, filepath.Join(location, tt.wantRecorded))
+			}
+		})
+	}
+}
+
+func TestPutIoDownloader_Register_external(t *testing.T) {
+	r, downloadDir, cleanup := newTestDownloader(t)
+	defer cleanup()
+	const magnet = "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=Show"
+	entry, _, err := r.Registry.Add("c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "Show", magnet, downloadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Registry.Update(entry.Hash, func(e *registry.Entry) { e.External = true }); err != nil {
+		t.Fatal(err)
+	}
+	options := AddOptions{Paused: true, Labels: []string{"tv"}, BandwidthPriority: 1, Unwanted: []int{2}}
+
+	got, added, err := r.Register(magnet, downloadDir, options)
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if added {
+		t.Error("Register() added a torrent already on put.io")
+	}
+	if got.External || !got.Paused {
+		t.Errorf("Register() external = %v, paused = %v, want false, true", got.External, got.Paused)
+	}
+	if len(got.Labels) != 1 || got.Labels[0] != "tv" || got.BandwidthPriority != 1 || got.Wanted(2) {
+		t.Errorf("Register() didn't apply the add options: %+v", got)
+	}
+	if r.Active(entry.Hash) {
+		t.Error("Register() started a torrent added paused")
+	}
+}
+
+func TestPutIoDownloader_FetchMagnetFile_claimed(t *testing.T) {
+	r, downloadDir, cleanup := newTestDownloader(t)
+	defer cleanup()
+	// No put.io transfers, without asking put.io.
+	r.snapshot.listed = true
+	close(r.snapshot.ready)
+	const hash = "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	// Stands in for the download, which carries on after the file is claimed.
+	j := r.jobs.start(hash, downloadDir, false)
+	defer r.jobs.finish(j)
+	file := filepath.Join(filepath.Dir(downloadDir), "show.magnet")
+	if err := ioutil.WriteFile(file, []byte("magnet:?xt=urn:btih:"+hash+"&dn=Show\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Created, then written, as the watcher may report it.
+	for i := 0; i < 2; i++ {
+		if _, err := r.FetchMagnetFile(file, downloadDir); err == nil {
+			t.Fatalf("FetchMagnetFile() call %d error = nil, want the download in progress", i+1)
+		}
+	}
+	if _, ok := r.Registry.ByHash(hash); !ok {
+		t.Error("FetchMagnetFile() didn't register the torrent")
+	}
+	if !exists(file + ".done") {
+		t.Error("magnet file not renamed .done once registered")
+	}
+	if exists(file + ".duplicate") {
+		t.Error("magnet file taken for a duplicate of itself")
+	}
+}
@@ -0,0 +1,110 @@
+package torrent
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+)
+
+// downloadQueue limits how many torrents download locally at once, to the
+// session's download-queue-size.  Waiting torrents go in order of bandwidth
+// priority, then queue position.
+type downloadQueue struct {
+	registry *registry.Registry
+	size     func() int // Zero or less for no limit.
+
+	mu      sync.Mutex
+	active  int
+	waiting map[string]chan struct{} // By hash, closed when the torrent may start.
+}
+
+func newDownloadQueue(reg *registry.Registry) *downloadQueue {
+	return &downloadQueue{
+		registry: reg,
+		size: func() int {
+			if !config.GetBool("downloadQueueEnabled") {
+				return 0
+			}
+			return config.GetInt("downloadQueueSize")
+		},
+		waiting: make(map[string]chan struct{}),
+	}
+}
+
+// acquire waits for the torrent's turn to download.  If it returns nil,
+// release must be called when the download stops.
+func (q *downloadQueue) acquire(ctx context.Context, hash string) error {
+	ready := make(chan struct{})
+	q.mu.Lock()
+	q.waiting[hash] = ready
+	q.dispatchLocked()
+	q.mu.Unlock()
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-ready:
+			// Started just as we gave up, so hand the slot on.
+			q.active--
+		default:
+			delete(q.waiting, hash)
+		}
+		q.dispatchLocked()
+		return ctx.Err()
+	}
+}
+
+func (q *downloadQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.dispatchLocked()
+}
+
+// queued reports whether a torrent is waiting for its turn.
+func (q *downloadQueue) queued(hash string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.waiting[hash]
+	return ok
+}
+
+// changed starts waiting torrents after the queue is reordered or resized.
+func (q *downloadQueue) changed() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dispatchLocked()
+}
+
+func (q *downloadQueue) dispatchLocked() {
+	if len(q.waiting) == 0 {
+		return
+	}
+	entries := make([]registry.Entry, 0, len(q.waiting))
+	for hash := range q.waiting {
+		entry, _ := q.registry.ByHash(hash)
+		entry.Hash = hash
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].BandwidthPriority != entries[j].BandwidthPriority {
+			return entries[i].BandwidthPriority > entries[j].BandwidthPriority
+		}
+		return entries[i].QueuePosition < entries[j].QueuePosition
+	})
+	size := q.size()
+	for _, entry := range entries {
+		if size > 0 && q.active >= size {
+			return
+		}
+		close(q.waiting[entry.Hash])
+		delete(q.waiting, entry.Hash)
+		q.active++
+	}
+}
@@ -0,0 +1,65 @@
+package torrent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+)
+
+func Test_downloadQueue(t *testing.T) {
+	r, _, cleanup := newTestDownloader(t)
+	defer cleanup()
+	reg := r.Registry
+	for _, hash := range []string{"a", "b", "c", "d"} {
+		if _, _, err := reg.Add(hash, hash, "", "/download"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reg.Update("d", func(e *registry.Entry) { e.BandwidthPriority = 1 }); err != nil {
+		t.Fatal(err)
+	}
+	q := newDownloadQueue(reg)
+	q.size = func() int { return 1 }
+
+	if err := q.acquire(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan string, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		if q.acquire(ctx, "b") == nil {
+			started <- "b"
+		}
+	}()
+	for _, hash := range []string{"c", "d"} {
+		go func(hash string) {
+			if q.acquire(context.Background(), hash) == nil {
+				started <- hash
+			}
+		}(hash)
+	}
+	for !q.queued("b") || !q.queued("c") || !q.queued("d") {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case hash := <-started:
+		t.Fatalf("%s started while the queue was full", hash)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// b gives up waiting, then d goes before c on priority.
+	cancel()
+	for q.queued("b") {
+		time.Sleep(time.Millisecond)
+	}
+	q.release()
+	if hash := <-started; hash != "d" {
+		t.Errorf("%s started first, want d", hash)
+	}
+	q.release()
+	if hash := <-started; hash != "c" {
+		t.Errorf("%s started second, want c", hash)
+	}
+}
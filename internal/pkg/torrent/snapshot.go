@@ -0,0 +1,285 @@
+package torrent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+	"github.com/igungor/go-putio/putio"
+)
+
+// The longest the poller backs off for after put.io errors.
+const maxPollBackoff = 5 * time.Minute
+
+// snapshot is the last known state of put.io's transfers, refreshed in the
+// background so torrent-get needn't wait on put.io.  File trees are kept for
+// completed transfers, whose files don't change.  Jobs waiting on a transfer
+// are handed its state from each refresh, instead of polling put.io themselves.
+type snapshot struct {
+	mu        sync.Mutex
+	transfers []putio.Transfer
+	files     map[int64][]putio.File   // By the transfer's file ID.
+	listed    bool                     // Whether transfers were ever listed.
+	err       error                    // From the last refresh.
+	ready     chan struct{}            // Closed after the first refresh.
+	watches   map[int64]*transferWatch // By transfer ID.
+}
+
+// transferWatch is a job waiting on a transfer.  It is sent the transfer when
+// its status changes, or otherwise once due, so each transfer keeps its own
+// backoff.
+type transferWatch struct {
+	since   time.Time // Transfers listed before this may be out of date, or not include it yet.
+	due     time.Time
+	status  string // As last sent.
+	updates chan transferUpdate
+}
+
+type transferUpdate struct {
+	transfer putio.Transfer
+	err      error
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		files:   make(map[int64][]putio.File),
+		ready:   make(chan struct{}),
+		watches: make(map[int64]*transferWatch),
+	}
+}
+
+// watch starts handing the transfer's state to the returned channel, from the
+// next refresh on.
+func (s *snapshot) watch(transferID int64) <-chan transferUpdate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := &transferWatch{since: time.Now(), updates: make(chan transferUpdate, 1)}
+	s.watches[transferID] = w
+	return w.updates
+}
+
+func (s *snapshot) unwatch(transferID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.watches, transferID)
+}
+
+// delay holds back the transfer's next update, unless its status changes.
+func (s *snapshot) delay(transferID int64, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w, ok := s.watches[transferID]; ok {
+		w.due = time.Now().Add(d)
+	}
+}
+
+// notifyLocked hands the transfers listed at listedAt to the jobs watching
+// them; callers must hold s.mu.
+func (s *snapshot) notifyLocked(transfers []putio.Transfer, listedAt time.Time) {
+	byID := make(map[int64]putio.Transfer, len(transfers))
+	for _, transfer := range transfers {
+		byID[transfer.ID] = transfer
+	}
+	now := time.Now()
+	for id, w := range s.watches {
+		if !w.since.Before(listedAt) {
+			// Listed before the job started watching, so may be out of date,
+			// e.g. still in error after a retry.
+			continue
+		}
+		transfer, ok := byID[id]
+		var update transferUpdate
+		switch {
+		case ok && (transfer.Status != w.status || !now.Before(w.due)):
+			w.status = transfer.Status
+			update = transferUpdate{transfer: transfer}
+		case !ok:
+			update = transferUpdate{err: putIoError{fmt.Sprintf("put.io transfer %d is gone", id)}}
+		default:
+			continue
+		}
+		// Replace any update the job hasn't picked up yet.
+		select {
+		case <-w.updates:
+		default:
+		}
+		w.updates <- update
+	}
+}
+
+// put records a transfer fetched or added outside the poller, so the
+// snapshot doesn't lag behind our own changes.
+func (s *snapshot) put(transfer putio.Transfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.transfers {
+		if s.transfers[i].ID == transfer.ID {
+			s.transfers[i] = transfer
+			return
+		}
+	}
+	s.transfers = append(s.transfers, transfer)
+}
+
+// drop forgets a cancelled transfer.
+func (s *snapshot) drop(transferID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.transfers {
+		if s.transfers[i].ID == transferID {
+			s.transfers = append(s.transfers[:i:i], s.transfers[i+1:]...)
+			return
+		}
+	}
+}
+
+// Transfers returns put.io's transfers as of the last refresh, waiting for the
+// first one if need be.  Stale transfers are returned if a refresh failed.
+func (r PutIoDownloader) Transfers() ([]putio.Transfer, error) {
+	s := r.snapshot
+	<-s.ready
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.listed {
+		return nil, s.err
+	}
+	return append([]putio.Transfer(nil), s.transfers...), nil
+}
+
+// TransferFiles lists the files of a transfer, as RecursiveList does, from
+// the snapshot when it has them.  Transfers whose files were deleted from
+// put.io, as ours are once downloaded, have none.
+func (r PutIoDownloader) TransferFiles(fileID int64) ([]putio.File, error) {
+	s := r.snapshot
+	s.mu.Lock()
+	files, ok := s.files[fileID]
+	s.mu.Unlock()
+	if ok {
+		return files, nil
+	}
+	files, err := r.RecursiveList(fileID, "")
+	if isNotFound(err) {
+		// Kept, so it isn't asked for again on every refresh.
+		log.Printf("put.io file %d is gone", fileID)
+		files, err = []putio.File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.keepFilesLocked(fileID, files)
+	s.mu.Unlock()
+	return files, nil
+}
+
+// keepFilesLocked keeps the file tree of a completed transfer, as those of
+// transfers still in progress may change; callers must hold s.mu.
+func (s *snapshot) keepFilesLocked(fileID int64, files []putio.File) {
+	for _, transfer := range s.transfers {
+		if transfer.FileID == fileID && transferCompleted(transfer) {
+			s.files[fileID] = files
+			return
+		}
+	}
+}
+
+func transferCompleted(transfer putio.Transfer) bool {
+	return transfer.Status == "COMPLETED" || transfer.Status == "SEEDING"
+}
+
+// pollTransfers refreshes the snapshot every putioPollInterval, backing off
+// while put.io is failing or rate limiting us.
+func (r PutIoDownloader) pollTransfers() {
+	failures := 0
+	for {
+		err := r.refreshSnapshot(context.TODO())
+		if err != nil {
+			failures++
+			log.Printf("Unable to refresh put.io transfers: %s", err.Error())
+		} else {
+			failures = 0
+		}
+		time.Sleep(pollDelay(config.GetDuration("putioPollInterval"), failures, err))
+	}
+}
+
+func (r PutIoDownloader) refreshSnapshot(ctx context.Context) error {
+	s := r.snapshot
+	defer func() {
+		select {
+		case <-s.ready:
+		default:
+			close(s.ready)
+		}
+	}()
+	listedAt := time.Now()
+	transfers, err := r.Client.Transfers.List(ctx)
+	if err != nil {
+		s.mu.Lock()
+		s.err = fmt.Errorf("unable to list put.io transfers: %s", err.Error())
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Lock()
+	files := make(map[int64][]putio.File, len(s.files))
+	var missing []int64
+	for _, transfer := range transfers {
+		if transfer.FileID == 0 || !transferCompleted(transfer) {
+			continue
+		}
+		if tree, ok := s.files[transfer.FileID]; ok {
+			files[transfer.FileID] = tree
+		} else {
+			missing = append(missing, transfer.FileID)
+		}
+	}
+	s.transfers, s.files, s.listed, s.err = transfers, files, true, nil
+	s.notifyLocked(transfers, listedAt)
+	s.mu.Unlock()
+	for _, fileID := range missing {
+		if _, err := r.TransferFiles(fileID); err != nil {
+			// Left for torrent-get to list, or the next refresh.
+			log.Printf("Unable to list put.io file %d: %s", fileID, err.Error())
+			if rateLimited(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pollDelay is how long to wait before the next refresh: the interval, or
+// after failures, double that for each failure in a row, up to maxPollBackoff.
+// put.io's Retry-After is honoured when rate limited.
+func pollDelay(interval time.Duration, failures int, err error) time.Duration {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if rateLimited(err) {
+		retryAfter := err.(*putio.ErrorResponse).Response.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	delay := interval
+	for i := 0; i < failures && delay < maxPollBackoff; i++ {
+		delay *= 2
+	}
+	if failures > 0 && delay > maxPollBackoff {
+		delay = maxPollBackoff
+	}
+	if delay < interval {
+		delay = interval
+	}
+	return delay
+}
+
+func rateLimited(err error) bool {
+	e, ok := err.(*putio.ErrorResponse)
+	return ok && e.Response != nil && e.Response.StatusCode == http.StatusTooManyRequests
+}
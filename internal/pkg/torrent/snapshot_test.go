@@ -0,0 +1,145 @@
+package torrent
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/igungor/go-putio/putio"
+)
+
+func rateLimitError(retryAfter string) error {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &putio.ErrorResponse{Response: &http.Response{StatusCode: http.StatusTooManyRequests, Header: header}}
+}
+
+func Test_pollDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		failures int
+		err      error
+		want     time.Duration
+	}{
+		{"Success", 5 * time.Second, 0, nil, 5 * time.Second},
+		{"Unset interval", 0, 0, nil, 5 * time.Second},
+		{"One failure", 5 * time.Second, 1, errors.New("EOF"), 10 * time.Second},
+		{"Three failures", 5 * time.Second, 3, errors.New("EOF"), 40 * time.Second},
+		{"Many failures", 5 * time.Second, 20, errors.New("EOF"), maxPollBackoff},
+		{"Long interval", time.Hour, 2, errors.New("EOF"), time.Hour},
+		{"Rate limited", 5 * time.Second, 1, rateLimitError("120"), 2 * time.Minute},
+		{"Rate limited, no Retry-After", 5 * time.Second, 2, rateLimitError(""), 20 * time.Second},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pollDelay(tt.interval, tt.failures, tt.err); got != tt.want {
+				t.Errorf("pollDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshot_putAndDrop(t *testing.T) {
+	s := newSnapshot()
+	s.put(putio.Transfer{ID: 1, Name: "one"})
+	s.put(putio.Transfer{ID: 2, Name: "two"})
+	s.put(putio.Transfer{ID: 1, Name: "one, updated"})
+	s.drop(2)
+	s.drop(3)
+	if len(s.transfers) != 1 || s.transfers[0].Name != "one, updated" {
+		t.Errorf("transfers = %v, want only the updated first transfer", s.transfers)
+	}
+}
+
+func TestSnapshot_notifyLocked(t *testing.T) {
+	downloading := putio.Transfer{ID: 1, Status: "DOWNLOADING"}
+	completed := putio.Transfer{ID: 1, Status: "COMPLETED"}
+	tests := []struct {
+		name       string
+		delay      time.Duration
+		transfers  []putio.Transfer
+		listedAt   time.Duration // Relative to when the watch started.
+		wantStatus string
+		wantErr    bool
+		wantNone   bool
+	}{
+		{"Due", 0, []putio.Transfer{downloading}, time.Second, "DOWNLOADING", false, false},
+		{"Not due", time.Hour, []putio.Transfer{downloading}, time.Second, "", false, true},
+		{"Not due, status changed", time.Hour, []putio.Transfer{completed}, time.Second, "COMPLETED", false, false},
+		{"Gone", 0, nil, time.Second, "", true, false},
+		{"Listed before watching", 0, nil, -time.Second, "", false, true},
+		{"Listed before watching, with the transfer", 0, []putio.Transfer{completed}, -time.Second, "", false, true},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSnapshot()
+			updates := s.watch(1)
+			s.notifyLocked([]putio.Transfer{downloading}, time.Now().Add(time.Second))
+			<-updates
+			s.delay(1, tt.delay)
+			s.notifyLocked(tt.transfers, s.watches[1].since.Add(tt.listedAt))
+			select {
+			case update := <-updates:
+				if tt.wantNone || (update.err != nil) != tt.wantErr || update.transfer.Status != tt.wantStatus {
+					t.Errorf("update = %v, want status %q, error %v", update, tt.wantStatus, tt.wantErr)
+				}
+			default:
+				if !tt.wantNone {
+					t.Errorf("no update, want status %q, error %v", tt.wantStatus, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshot_keepFilesLocked(t *testing.T) {
+	s := newSnapshot()
+	s.put(putio.Transfer{ID: 1, FileID: 10, Status: "DOWNLOADING"})
+	s.put(putio.Transfer{ID: 2, FileID: 20, Status: "COMPLETED"})
+	s.put(putio.Transfer{ID: 3, FileID: 30, Status: "SEEDING"})
+	files := []putio.File{{Name: "Show/e01.mkv"}}
+	for _, fileID := range []int64{10, 20, 30, 40} {
+		s.keepFilesLocked(fileID, files)
+	}
+	for fileID, want := range map[int64]bool{10: false, 20: true, 30: true, 40: false} {
+		if _, got := s.files[fileID]; got != want {
+			t.Errorf("kept files of %d = %v, want %v", fileID, got, want)
+		}
+	}
+}
+
+func TestPutIoDownloader_TransferFiles_notFound(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	r, _, cleanup := newTestDownloader(t)
+	defer cleanup()
+	r.Client = putio.NewClient(server.Client())
+	baseURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Client.BaseURL = baseURL
+	r.snapshot.put(putio.Transfer{ID: 1, FileID: 10, Status: "COMPLETED"})
+
+	for i := 0; i < 2; i++ {
+		files, err := r.TransferFiles(10)
+		if err != nil || len(files) != 0 {
+			t.Errorf("TransferFiles() call %d = %v, %v, want no files", i+1, files, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("put.io asked %d times, want once", requests)
+	}
+}
@@ -0,0 +1,217 @@
+package transmission
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+)
+
+// Typed access to request arguments.  Each returns an error naming the argument
+// when a client sends the wrong type, rather than panicking on an assertion.
+
+func (receiver *RPCRequest) stringArg(key string, required bool) (string, error) {
+	raw, ok := receiver.Arguments[key]
+	if !ok {
+		if required {
+			return "", fmt.Errorf("no %s specified", key)
+		}
+		return "", nil
+	}
+	v, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string", key)
+	}
+	return v, nil
+}
+
+// boolArg also accepts 0 and 1, as Transmission does.
+func (receiver *RPCRequest) boolArg(key string) (bool, error) {
+	raw, ok := receiver.Arguments[key]
+	if !ok {
+		return false, nil
+	}
+	switch v := raw.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	}
+	return false, fmt.Errorf("%s must be a boolean", key)
+}
+
+func (receiver *RPCRequest) intArg(key string) (int64, bool, error) {
+	raw, ok := receiver.Arguments[key]
+	if !ok {
+		return 0, false, nil
+	}
+	v, ok := raw.(float64)
+	if !ok || v != float64(int64(v)) {
+		return 0, true, fmt.Errorf("%s must be an integer", key)
+	}
+	return int64(v), true, nil
+}
+
+func (receiver *RPCRequest) stringsArg(key string, required bool) ([]string, error) {
+	raw, ok := receiver.Arguments[key]
+	if !ok {
+		if required {
+			return nil, fmt.Errorf("no %s specified", key)
+		}
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list of strings", key)
+	}
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		v, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be a list of strings", key)
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// labelsArg reads "labels", and whether it was sent.  As in Transmission,
+// labels are trimmed, and may be neither empty nor contain commas.
+func (receiver *RPCRequest) labelsArg() ([]string, bool, error) {
+	if _, ok := receiver.Arguments["labels"]; !ok {
+		return nil, false, nil
+	}
+	list, err := receiver.stringsArg("labels", false)
+	if err != nil {
+		return nil, true, err
+	}
+	var labels []string
+	for _, label := range list {
+		label = strings.TrimSpace(label)
+		if label == "" || strings.Contains(label, ",") {
+			return nil, true, fmt.Errorf("invalid label %q", label)
+		}
+		labels = append(labels, label)
+	}
+	return labels, true, nil
+}
+
+// downloadDirArg returns the torrent's "download-dir", or the session default.
+// Clients compare it against their own paths, so it is cleaned up here once.
+func (receiver *RPCRequest) downloadDirArg() (string, error) {
+	downloadDir, err := receiver.stringArg("download-dir", false)
+	if err != nil {
+		return "", err
+	}
+	if downloadDir == "" {
+		downloadDir = config.GetString("downloadTo")
+	}
+	if !filepath.IsAbs(downloadDir) {
+		return "", errors.New("download directory path is not absolute")
+	}
+	return filepath.Clean(downloadDir), nil
+}
+
+// torrents resolves the "ids" argument, failing on unknown torrents.
+func (receiver *RPCRequest) torrents() ([]registry.Entry, error) {
+	selector, err := parseIDs(receiver.Arguments)
+	if err != nil {
+		return nil, err
+	}
+	return selector.lookup()
+}
+
+// indicesArg reads a list of file indices.  Like Transmission, an empty list
+// means every file, which is reported as all.
+func (receiver *RPCRequest) indicesArg(key string) (indices []int, all bool, err error) {
+	raw, ok := receiver.Arguments[key]
+	if !ok {
+		return nil, false, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, false, fmt.Errorf("%s must be a list of file indices", key)
+	}
+	for _, item := range list {
+		v, ok := item.(float64)
+		if !ok || v < 0 || v != float64(int(v)) {
+			return nil, false, fmt.Errorf("%s must be a list of file indices", key)
+		}
+		indices = append(indices, int(v))
+	}
+	return indices, len(list) == 0, nil
+}
+
+// fileSelection is a request's files-wanted and files-unwanted, and
+// priority-high, priority-normal and priority-low.
+type fileSelection struct {
+	present          bool // Whether any of them were sent.
+	wanted, unwanted []int
+	allWanted        bool
+	priorities       map[int64][]int
+	allNormal        bool
+}
+
+func (receiver *RPCRequest) fileSelection() (fileSelection, error) {
+	var s fileSelection
+	for _, key := range []string{"files-wanted", "files-unwanted", "priority-high", "priority-normal", "priority-low"} {
+		if _, ok := receiver.Arguments[key]; ok {
+			s.present = true
+		}
+	}
+	var err error
+	if s.wanted, s.allWanted, err = receiver.indicesArg("files-wanted"); err != nil {
+		return s, err
+	}
+	var allUnwanted bool
+	if s.unwanted, allUnwanted, err = receiver.indicesArg("files-unwanted"); err != nil {
+		return s, err
+	}
+	if allUnwanted {
+		// We may not know how many files there are yet.
+		log.Printf("Ignoring empty files-unwanted")
+	}
+	s.priorities = make(map[int64][]int)
+	for key, priority := range map[string]int64{"priority-high": 1, "priority-normal": 0, "priority-low": -1} {
+		indices, all, err := receiver.indicesArg(key)
+		if err != nil {
+			return s, err
+		}
+		switch {
+		case all && priority == 0:
+			s.allNormal = true
+		case all:
+			log.Printf("Ignoring empty %s", key)
+		}
+		s.priorities[priority] = indices
+	}
+	return s, nil
+}
+
+func (s fileSelection) apply(e *registry.Entry) {
+	e.SetWanted(s.unwanted, false)
+	if s.allWanted {
+		e.Unwanted = nil
+	}
+	e.SetWanted(s.wanted, true)
+	if s.allNormal {
+		e.Priorities = nil
+	}
+	for _, priority := range []int64{-1, 0, 1} {
+		e.SetPriority(s.priorities[priority], priority)
+	}
+}
+
+// changes reports whether applying the selection would change the entry.
+func (s fileSelection) changes(e registry.Entry) bool {
+	updated := e
+	s.apply(&updated)
+	return !reflect.DeepEqual(updated.Unwanted, e.Unwanted) || !reflect.DeepEqual(updated.Priorities, e.Priorities)
+}
@@ -0,0 +1,119 @@
+package transmission
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+)
+
+const saltAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789./"
+
+// hashPassword salts and hashes a password the way Transmission stores
+// rpc-password: "{", the hex SHA-1 of password and salt, then the salt.
+func hashPassword(password string) string {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	for i, b := range salt {
+		salt[i] = saltAlphabet[int(b)%len(saltAlphabet)]
+	}
+	return saltedHash(password, string(salt))
+}
+
+func saltedHash(password, salt string) string {
+	sum := sha1.Sum([]byte(password + salt))
+	return "{" + hex.EncodeToString(sum[:]) + salt
+}
+
+func isHashedPassword(s string) bool {
+	return strings.HasPrefix(s, "{") && len(s) > 1+2*sha1.Size
+}
+
+func checkPassword(hashed, password string) bool {
+	if !isHashedPassword(hashed) {
+		return false
+	}
+	want := saltedHash(password, hashed[1+2*sha1.Size:])
+	return subtle.ConstantTimeCompare([]byte(want), []byte(hashed)) == 1
+}
+
+// hashConfiguredPassword replaces a plain text rpcPassword in the config with
+// its hash, as Transmission does with settings.json.
+func hashConfiguredPassword() {
+	password := config.GetString("rpcPassword")
+	if password == "" || isHashedPassword(password) {
+		return
+	}
+	if err := config.Set(map[string]interface{}{"rpcPassword": hashPassword(password)}); err != nil {
+		log.Printf("Unable to save hashed rpcPassword: %s", err.Error())
+	}
+}
+
+// matchesList reports whether s matches any of a comma separated list of
+// patterns, which may use * and ? wildcards.
+func matchesList(list, s string) bool {
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed guards against DNS rebinding: the Host header must be an IP
+// address, localhost or on the host whitelist.  Like Transmission, this is
+// only checked when no password is required.
+func hostAllowed(r *http.Request) bool {
+	if !config.GetBool("rpcHostWhitelistEnabled") || config.GetBool("rpcAuthenticationRequired") {
+		return true
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if net.ParseIP(host) != nil || host == "localhost" || host == "localhost." {
+		return true
+	}
+	return matchesList(config.GetString("rpcHostWhitelist"), host)
+}
+
+// authorize checks a request against the whitelists and credentials, replying
+// with an error and returning false if it isn't allowed.
+func authorize(w http.ResponseWriter, r *http.Request, client string) bool {
+	if config.GetBool("rpcWhitelistEnabled") && !matchesList(config.GetString("rpcWhitelist"), client) {
+		log.Printf("Rejected RPC request from %s, not on the whitelist", client)
+		http.Error(w, "Unauthorized IP Address.", http.StatusForbidden)
+		return false
+	}
+	if !hostAllowed(r) {
+		log.Printf("Rejected RPC request for host %s", r.Host)
+		http.Error(w, "Transmission received your request, but the hostname was unrecognized.", http.StatusMisdirectedRequest)
+		return false
+	}
+	if !config.GetBool("rpcAuthenticationRequired") {
+		return true
+	}
+	username, password, ok := r.BasicAuth()
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(config.GetString("rpcUsername"))) == 1
+	if !ok || !usernameOK || !checkPassword(config.GetString("rpcPassword"), password) {
+		log.Printf("Rejected RPC request from %s, bad credentials", client)
+		w.Header().Set("WWW-Authenticate", `Basic realm="Transmission"`)
+		http.Error(w, "Unauthorized User", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package transmission
+
+import "testing"
+
+func Test_checkPassword(t *testing.T) {
+	hashed := hashPassword("secret")
+	tests := []struct {
+		name     string
+		hashed   string
+		password string
+		want     bool
+	}{
+		{name: "Right password", hashed: hashed, password: "secret", want: true},
+		{name: "Wrong password", hashed: hashed, password: "Secret", want: false},
+		{name: "Fixed salt", hashed: saltedHash("x", "abcdefgh"), password: "x", want: true},
+		{name: "Plain text", hashed: "secret", password: "secret", want: false},
+		{name: "Empty", hashed: "", password: "", want: false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkPassword(tt.hashed, tt.password); got != tt.want {
+				t.Errorf("checkPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_matchesList(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		s    string
+		want bool
+	}{
+		{name: "Exact", list: "127.0.0.1,::1", s: "::1", want: true},
+		{name: "Wildcard", list: "127.0.0.1, 192.168.*.*", s: "192.168.1.20", want: true},
+		{name: "No match", list: "127.0.0.1,192.168.*.*", s: "10.0.0.1", want: false},
+		{name: "Hostname", list: "*.example.com", s: "nas.example.com", want: true},
+		{name: "Empty list", list: "", s: "127.0.0.1", want: false},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesList(tt.list, tt.s); got != tt.want {
+				t.Errorf("matchesList() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
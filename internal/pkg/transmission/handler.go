@@ -3,236 +3,290 @@ package transmission
 
 import (
 	"bytes"
-	"context"
-	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
-	"hash/fnv"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
 	"github.com/anonfunc/transmissio/internal/pkg/torrent"
-	"github.com/spf13/viper"
+	"github.com/igungor/go-putio/putio"
 
 	"golang.org/x/sys/unix"
 )
 
 const sessionIDHeader = "X-Transmission-Session-Id"
 
-var knownSessionID string
 var Downloader *torrent.PutIoDownloader
 
 func Initialize() {
-	sessionRandomBytes := make([]byte, 16)
-	_, err := rand.Read(sessionRandomBytes)
-	if err != nil {
-		panic(err)
+	rotateSessionID()
+	if interval := config.GetDuration("sessionIdRotation"); interval > 0 {
+		go rotateSessionIDEvery(interval)
 	}
-	knownSessionID = base64.StdEncoding.EncodeToString(sessionRandomBytes)
+	hashConfiguredPassword()
 	unix.Umask(000) // TODO Is this appropriate outside of Docker?
 }
 
 func (receiver *RPCRequest) DoIt() (*RPCResponse, error) {
-	response := &RPCResponse{Tag: receiver.Tag}
+	response := &RPCResponse{Tag: receiver.Tag, Result: "success"}
+	var err error
+	response.Arguments, err = receiver.call()
+	if err != nil {
+		response.Result = err.Error()
+	}
+	if err != nil || response.Arguments == nil {
+		// Transmission always sends an object, and a failed handler's typed
+		// nil pointer would otherwise be sent as null.
+		response.Arguments = map[string]interface{}{}
+	}
+	return response, nil
+}
+
+var errMethodNotRecognized = errors.New("method name not recognized")
+
+// call runs the request's method, returning its arguments for the response.
+func (receiver *RPCRequest) call() (interface{}, error) {
+	var arguments interface{}
+	var err error
 	switch receiver.Method {
 	case "session-get":
-		response.Arguments = SessionInfo{
-			Version:           "2.98",
-			RPCVersion:        "10",
-			RPCVersionMinimum: "10",
-			SpeedLimitDown:    10000,
-			SpeedLimitUp:      10000,
-		}
+		_, err = receiver.stringsArg("fields", false)
+		arguments = sessionGet()
+	case "session-set":
+		err = receiver.sessionSet()
+	case "session-close":
+		// We keep running, but clients must renegotiate the session.
+		rotateSessionID()
+	case "session-stats":
+		arguments = sessionStats()
 	// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L86
-	case "torrent-start":
-	case "torrent-start-now":
+	case "torrent-start", "torrent-start-now":
+		err = receiver.eachTorrent(Downloader.Start)
 	case "torrent-stop":
-	case "torrent-verify":
-	case "torrent-reannounce":
+		err = receiver.eachTorrent(Downloader.Stop)
+	case "torrent-verify", "torrent-reannounce":
+		_, err = receiver.torrents()
 	case "torrent-set":
 		// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L105
+		err = receiver.torrentSet()
 	case "torrent-get":
 		// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L144
-		response.Arguments = receiver.torrentGet()
+		arguments, err = receiver.torrentGet()
 	case "torrent-add":
-		response.Arguments = receiver.torrentAdd()
+		arguments, err = receiver.torrentAdd()
 	case "torrent-remove":
 		// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L407
+		err = receiver.torrentRemove()
 	case "torrent-set-location":
 		// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L423
+		err = receiver.torrentSetLocation()
 	case "torrent-rename-path":
 		// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L440
-	// Session stuff, make no-ops?
+		arguments, err = receiver.torrentRenamePath()
+	case "queue-move-top", "queue-move-up", "queue-move-down", "queue-move-bottom":
+		err = receiver.queueMove(strings.TrimPrefix(receiver.Method, "queue-move-"))
 	case "free-space":
 		// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L623
-		// Should this be put.io space?
+		arguments, err = receiver.freeSpace()
 	case "":
 		// Ping from nzb360 et al.
 	default:
 		log.Printf("unhandled method %s", receiver.Method)
+		err = errMethodNotRecognized
 	}
-	response.Result = "success"
-	return response, nil
+	if err != nil {
+		log.Printf("%s failed: %s", receiver.Method, err.Error())
+	}
+	return arguments, err
 }
 
-func (receiver *RPCRequest) torrentAdd() (result TorrentAdd) {
-	filename := receiver.Arguments["filename"].(string)
-	metainfoI := receiver.Arguments["metainfo"]
-	var downloadTo string
-	switch v := receiver.Arguments["download-dir"].(type) {
-	case string:
-		downloadTo = v
-	default:
-		downloadTo = viper.GetString("downloadTo")
+var errInvalidTorrent = errors.New("invalid or corrupt torrent file")
+
+func (receiver *RPCRequest) torrentAdd() (TorrentAdd, error) {
+	var result TorrentAdd
+	filename, err := receiver.stringArg("filename", false)
+	if err != nil {
+		return result, err
 	}
-	if strings.HasPrefix(filename, "magnet:") {
-		Downloader.AsyncFetchMagnetLink(filename, downloadTo)
-		mi, err := metainfo.ParseMagnetURI(filename)
-		if err != nil {
-			log.Printf("Unable to parse magnet URI")
-			return
-		}
-		h := fnv.New32a()
-		if _, err := h.Write(mi.InfoHash.Bytes()); err != nil {
-			log.Printf("Unable to make filename into ID, %s\n", err.Error())
-		}
-		id := int64(h.Sum32())
-		result.TorrentAdded = &TorrentInfoSmall{
-			ID:         id,
-			Name:       mi.DisplayName,
-			HashString: strings.ToLower(mi.InfoHash.HexString()),
-		}
-	} else if metainfoI != nil {
-		metaBytes, err := base64.StdEncoding.DecodeString(metainfoI.(string))
+	metainfoB64, err := receiver.stringArg("metainfo", false)
+	if err != nil {
+		return result, err
+	}
+	cookies, err := receiver.stringArg("cookies", false)
+	if err != nil {
+		return result, err
+	}
+	downloadTo, err := receiver.downloadDirArg()
+	if err != nil {
+		return result, err
+	}
+	options, err := receiver.addOptions()
+	if err != nil {
+		return result, err
+	}
+	var magnetLink string
+	switch {
+	case strings.HasPrefix(filename, "magnet:"):
+		magnetLink = filename
+	case metainfoB64 != "":
+		metaBytes, err := base64.StdEncoding.DecodeString(metainfoB64)
 		if err != nil {
-			return
+			return result, errInvalidTorrent
 		}
-		mi, err := metainfo.Load(bytes.NewBuffer(metaBytes))
+		magnetLink, err = metainfoToMagnetLink(metaBytes)
 		if err != nil {
-			log.Printf("error loading torrent base64: %s", err.Error())
-			return
+			return result, err
 		}
-		info, err := mi.UnmarshalInfo()
+	case strings.HasPrefix(filename, "http://") || strings.HasPrefix(filename, "https://"):
+		magnetLink, err = fetchTorrentURL(filename, cookies)
 		if err != nil {
-			log.Printf("error converting torrent: %s", err.Error())
-			return
+			return result, err
 		}
-		hashBytes := mi.HashInfoBytes()
-		h := fnv.New32a()
-		if _, err := h.Write(hashBytes.Bytes()); err != nil {
-			log.Printf("Unable to make hash into ID, %s\n", err.Error())
-		}
-		result.TorrentAdded = &TorrentInfoSmall{
-			ID:         int64(h.Sum32()),
-			Name:       filename,
-			HashString: strings.ToLower(hashBytes.HexString()),
-		}
-		magnetLink := mi.Magnet(info.Name, hashBytes).String()
-		Downloader.AsyncFetchMagnetLink(magnetLink, downloadTo)
+	case filename != "":
+		return result, fmt.Errorf("unsupported filename %s", filename)
+	default:
+		return result, errors.New("no filename or metainfo specified")
+	}
+	if _, err := metainfo.ParseMagnetURI(magnetLink); err != nil {
+		log.Printf("Unable to parse %s: %s", magnetLink, err.Error())
+		return result, errInvalidTorrent
+	}
+	entry, err := Downloader.AsyncFetchMagnetLink(magnetLink, downloadTo, options)
+	info := &TorrentInfoSmall{
+		ID:         entry.ID,
+		Name:       entry.Name,
+		HashString: entry.Hash,
+	}
+	if err == torrent.ErrDuplicate {
+		// Not an error for Transmission, which returns the existing torrent.
+		log.Printf("Duplicate torrent %d %s", entry.ID, entry.Name)
+		result.TorrentDuplicate = info
+		return result, nil
+	}
+	if err != nil {
+		// Most likely put.io or the registry failing, so worth trying again.
+		log.Printf("Unable to add %s: %s", magnetLink, err.Error())
+		return result, err
 	}
-	return result
+	result.TorrentAdded = info
+	return result, nil
 }
 
-func (receiver *RPCRequest) torrentGet() TorrentGet {
-	transfers, err := Downloader.Client.Transfers.List(context.TODO())
+func (receiver *RPCRequest) torrentGet() (TorrentGet, error) {
+	selector, err := parseIDs(receiver.Arguments)
+	if err != nil {
+		return TorrentGet{}, err
+	}
+	fields, err := receiver.stringsArg("fields", true)
+	if err != nil {
+		return TorrentGet{}, err
+	}
+	format, err := receiver.stringArg("format", false)
+	if err != nil {
+		return TorrentGet{}, err
+	}
+	if format != "" && format != "objects" && format != "table" {
+		return TorrentGet{}, fmt.Errorf("unsupported format %s", format)
+	}
+	transfers, err := Downloader.Transfers()
 	if err != nil {
-		log.Printf("error in torrentGet: %s", err.Error())
-		return TorrentGet{}
+		return TorrentGet{}, err
 	}
-	var fields []interface{}
-	if receiver.Arguments["fields"] != nil {
-		fields = receiver.Arguments["fields"].([]interface{})
+	torrents := make([]interface{}, 0, len(transfers)+1)
+	if format == "table" {
+		header := make([]interface{}, len(fields))
+		for i, field := range fields {
+			header[i] = field
+			if receiver.snakeCase {
+				header[i] = snakeCaseName(field)
+			}
+		}
+		torrents = append(torrents, header)
 	}
-	idsSearchRaw, idsSearchGiven := receiver.Arguments["ids"]
-	idsSearch, idSearchIsSlice := idsSearchRaw.([]interface{})
-	torrents := make([]TorrentInfo, 0, len(transfers))
+	listed := make(map[int64]bool, len(transfers))
+	var known []entryTransfer
 	for _, transfer := range transfers {
 		log.Printf("Active Transfer: %v", transfer)
-		var status int64
-		switch transfer.Status {
-		case "DOWNLOADING":
-			status = 4
-		case "IN_QUEUE":
-			status = 3
-		case "COMPLETED":
-			status = 4 // Downloading makes sense, since we clear after we DL.
-			transfer.Downloaded = int64(transfer.Size)
-			transfer.Availability = 100
-		case "SEEDING":
-			status = 6
-		default:
-			log.Printf("unknown status %s", transfer.Status)
-			status = 7
-		}
-		var id int64
-		var hash string
-		if strings.HasPrefix(transfer.Source, "magnet:") {
-			mi, err := metainfo.ParseMagnetURI(transfer.Source)
-			if err != nil {
-				log.Printf("Unabled to parse magnet URI %s", err.Error())
-				id = transfer.ID
-				hash = "hash"
-			} else {
-				h := fnv.New32a()
-				if _, err := h.Write(mi.InfoHash.Bytes()); err != nil {
-					log.Printf("Unable to make filename into ID, %s\n", err.Error())
-				}
-				id = int64(h.Sum32())
-				hash = strings.ToLower(mi.InfoHash.HexString())
-			}
-		} else {
-			// TODO Stable ID and hash for torrent files.
-			id, hash = torrentLinkToIDAndHash(transfer.Source)
-			log.Printf("No magnet URI, fetched and derived %d and %s", id, hash)
-		}
-
-		if idsSearchGiven && idSearchIsSlice {
-			var match bool
-		outer:
-			for _, idRaw := range idsSearch {
-				switch searchID := idRaw.(type) {
-				case int64:
-					if id == searchID {
-						match = true
-						break outer
-					}
-				case string:
-					if hash == strings.ToLower(searchID) {
-						match = true
-						break outer
-					}
-				default:
-					// TODO
-					match = true
-				}
+		listed[transfer.ID] = true
+		entry, err := transferEntry(transfer)
+		if err != nil {
+			log.Printf("Unable to register transfer %d: %s", transfer.ID, err.Error())
+			continue
+		}
+		known = append(known, entryTransfer{entry, transfer})
+	}
+	// Torrents not submitted to put.io yet, such as those added paused, or
+	// submitted since put.io's transfers were last listed.
+	for _, entry := range Downloader.Registry.Entries() {
+		if entry.TransferID == 0 || !listed[entry.TransferID] && Downloader.Active(entry.Hash) {
+			known = append(known, entryTransfer{entry, putio.Transfer{Name: entry.Name, Status: "IN_QUEUE"}})
+		}
+	}
+	for _, et := range known {
+		entry, transfer := et.entry, et.transfer
+		entry.Paused = Downloader.Paused(entry.Hash)
+		progress, ok := Downloader.Progress(entry.Hash)
+		var files []putio.File
+		listFiles := func() ([]putio.File, error) {
+			if files != nil || transfer.FileID == 0 {
+				return files, nil
 			}
-			if !match {
-				continue
+			var err error
+			files, err = Downloader.TransferFiles(transfer.FileID)
+			return files, err
+		}
+		size := int64(transfer.Size)
+		if len(entry.Unwanted) > 0 {
+			if files, err := listFiles(); err != nil {
+				log.Printf("error listing files, %s", err.Error())
+			} else {
+				size = wantedSize(entry, files, size)
 			}
 		}
+		state := stateOf(entry, transfer, size, progress, ok)
+		if state.status == statusUnknown {
+			log.Printf("unknown status %s", transfer.Status)
+		}
+		id := entry.ID
+		activity.observe(entry.Hash, state.status, state.have)
+		if !selector.matches(entry) {
+			continue
+		}
 
 		torrentInfo := TorrentInfo{}
-		for _, vi := range fields {
-			v := vi.(string)
+		for _, v := range fields {
 			switch {
 			case v == "id":
 				torrentInfo.ID = id
 			case v == "name":
-				torrentInfo.Name = transfer.Name
+				torrentInfo.Name = entry.RenamedPath(transfer.Name)
 			case v == "error":
-				var i int64 // TODO What to put here?
+				i := state.errorCode
 				torrentInfo.Error = &i
 			case v == "errorString":
-				torrentInfo.ErrorString = transfer.StatusMessage
+				torrentInfo.ErrorString = state.errorString
 			case v == "status":
-				torrentInfo.Status = status
+				torrentInfo.Status = state.status
 			case v == "downloadDir":
-				torrentInfo.DownloadDir = viper.GetString("downloadTo")
+				torrentInfo.DownloadDir = entry.DownloadDir
+				if torrentInfo.DownloadDir == "" {
+					torrentInfo.DownloadDir = config.GetString("downloadTo")
+				}
 			case v == "rateDownload":
-				i := int64(transfer.DownloadSpeed)
+				i := state.rate
 				torrentInfo.RateDownload = &i
 			case v == "rateUpload":
 				i := int64(transfer.UploadSpeed)
@@ -247,103 +301,547 @@ func (receiver *RPCRequest) torrentGet() TorrentGet {
 				i := int64(transfer.PeersConnected)
 				torrentInfo.PeersConnected = &i
 			case v == "eta":
-				torrentInfo.Eta = transfer.EstimatedTime
+				torrentInfo.Eta = state.eta
 			case v == "haveValid":
-				torrentInfo.HaveValid = &transfer.Downloaded
+				i := state.have
+				torrentInfo.HaveValid = &i
 			case v == "uploadedEver":
 				torrentInfo.UploadedEver = &transfer.Uploaded
 			case v == "sizeWhenDone":
-				torrentInfo.SizeWhenDone = int64(transfer.Size)
+				torrentInfo.SizeWhenDone = state.size
+			case v == "totalSize":
+				torrentInfo.TotalSize = int64(transfer.Size)
+			case v == "hashString":
+				torrentInfo.HashString = entry.Hash
+			case v == "magnetLink":
+				torrentInfo.MagnetLink = magnetLink(entry)
+			case v == "metadataPercentComplete":
+				if transfer.Size > 0 {
+					torrentInfo.MetadataPercentComplete = 1
+				}
+			case v == "labels":
+				torrentInfo.Labels = entry.Labels
+			case v == "bandwidthPriority":
+				torrentInfo.BandwidthPriority = entry.BandwidthPriority
+			case v == "queuePosition":
+				torrentInfo.QueuePosition = int64(entry.QueuePosition)
+			case v == "file-count" || v == "primary-mime-type":
+				files, err := listFiles()
+				if err != nil {
+					log.Printf("error listing files, %s", err.Error())
+					continue
+				}
+				torrentInfo.FileCount = int64(len(files))
+				torrentInfo.PrimaryMimeType = primaryMimeType(files)
+			case v == "leftUntilDone":
+				i := state.left()
+				torrentInfo.LeftUntilDone = &i
 			case v == "desiredAvailable":
 				i := int64(transfer.Availability)
 				torrentInfo.DesiredAvailable = &i
 			case v == "comment":
 				torrentInfo.Comment = transfer.StatusMessage
 			case v == "percentDone":
-				torrentInfo.PercentDone = float32(transfer.Downloaded) / float32(transfer.Size)
+				torrentInfo.PercentDone = state.percentDone()
 			case v == "isFinished":
-				torrentInfo.IsFinished = status >= 4
+				torrentInfo.IsFinished = state.finished
 			case v == "addedDate":
 				if transfer.CreatedAt != nil {
 					torrentInfo.AddedDate = transfer.CreatedAt.Unix()
 				}
 			case v == "doneDate":
-				if transfer.FinishedAt != nil {
+				if !entry.DoneDate.IsZero() {
+					torrentInfo.DoneDate = entry.DoneDate.Unix()
+				} else if entry.External && transfer.FinishedAt != nil {
 					torrentInfo.DoneDate = transfer.FinishedAt.Unix()
 				}
 			case v == "files":
-				files, err := Downloader.RecursiveList(transfer.FileID, viper.GetString("downloadTo"))
+				files, err := listFiles()
 				if err != nil {
 					log.Printf("error listing files, %s", err.Error())
 					continue
 				}
-				for _, f := range files {
+				for i, f := range files {
 					torrentInfo.Files = append(torrentInfo.Files, FileInfo{
-						BytesCompleted: f.Size * transfer.Downloaded / int64(transfer.Size), // Fake percentage.
+						BytesCompleted: bytesCompleted(entry, i, f, state),
 						Length:         f.Size,
-						Name:           f.Name,
+						Name:           entry.RenamedPath(filepath.ToSlash(f.Name)),
 					})
 				}
+			case v == "fileStats" || v == "wanted" || v == "priorities":
+				files, err := listFiles()
+				if err != nil {
+					log.Printf("error listing files, %s", err.Error())
+					continue
+				}
+				torrentInfo.FileStats = make([]FileStat, len(files))
+				torrentInfo.Wanted = make([]bool, len(files))
+				torrentInfo.Priorities = make([]int64, len(files))
+				for i, f := range files {
+					torrentInfo.Wanted[i] = entry.Wanted(i)
+					torrentInfo.Priorities[i] = entry.Priority(i)
+					torrentInfo.FileStats[i] = FileStat{
+						BytesCompleted: bytesCompleted(entry, i, f, state),
+						Wanted:         entry.Wanted(i),
+						Priority:       entry.Priority(i),
+					}
+				}
 			}
 		}
 		log.Printf("ti: %v", torrentInfo)
-		torrents = append(torrents, torrentInfo)
+		if format == "table" {
+			torrents = append(torrents, torrentRow(torrentInfo, fields))
+		} else {
+			torrents = append(torrents, torrentObject(torrentInfo, fields))
+		}
 	}
-	return TorrentGet{
+	pruneVanishedTransfers(listed)
+	result := TorrentGet{
 		Torrents: torrents,
 	}
+	if selector.recentlyActive {
+		result.Removed = activity.removed()
+	}
+	return result, nil
 }
 
-var torrentLinkInfoCache = make(map[string]*metainfo.MetaInfo)
+type entryTransfer struct {
+	entry    registry.Entry
+	transfer putio.Transfer
+}
 
-func torrentLinkToIDAndHash(torrentLink string) (int64, string) {
-	if torrentLinkInfoCache[torrentLink] == nil {
-		log.Printf("Retrieving torrent %s", torrentLink)
-		// TODO Smarter max cache size.
-		if len(torrentLinkInfoCache) > 1000 {
-			torrentLinkInfoCache = make(map[string]*metainfo.MetaInfo)
+// magnetLink is the torrent's magnet link, made up from its hash if it was
+// added some other way.
+func magnetLink(entry registry.Entry) string {
+	if strings.HasPrefix(entry.Source, "magnet:") {
+		return entry.Source
+	}
+	magnet := metainfo.Magnet{InfoHash: metainfo.NewHashFromHex(entry.Hash), DisplayName: entry.Name}
+	return magnet.String()
+}
+
+// bytesCompleted fakes a file's progress from the torrent's.  Files not
+// wanted locally make none.
+func bytesCompleted(entry registry.Entry, index int, file putio.File, state torrentState) int64 {
+	if !entry.Wanted(index) {
+		return 0
+	}
+	return int64(float32(file.Size) * state.percentDone())
+}
+
+// wantedSize is size less the files not wanted locally.
+func wantedSize(entry registry.Entry, files []putio.File, size int64) int64 {
+	for i, f := range files {
+		if !entry.Wanted(i) {
+			size -= f.Size
 		}
-		resp, err := http.Get(torrentLink) //nolint:gosec
-		if err != nil {
-			log.Printf("Error retrieving torrent link: %s", err.Error())
-			return 0, ""
+	}
+	return size
+}
+
+// primaryMimeType is the content type making up most of a torrent, by size.
+func primaryMimeType(files []putio.File) string {
+	sizes := make(map[string]int64)
+	var primary string
+	for _, f := range files {
+		sizes[f.ContentType] += f.Size
+		if primary == "" || sizes[f.ContentType] > sizes[primary] {
+			primary = f.ContentType
+		}
+	}
+	return primary
+}
+
+// pruneVanishedTransfers unregisters torrents whose put.io transfer was removed
+// behind our back, e.g. from the put.io web interface.
+func pruneVanishedTransfers(listed map[int64]bool) {
+	for _, entry := range Downloader.Registry.Entries() {
+		if entry.TransferID == 0 || listed[entry.TransferID] || Downloader.Active(entry.Hash) {
+			continue
+		}
+		log.Printf("Transfer %d for %s is gone from put.io, unregistering", entry.TransferID, entry.Name)
+		if err := Downloader.Registry.Remove(entry.Hash); err != nil {
+			log.Printf("Unable to unregister %s: %s", entry.Hash, err.Error())
+		}
+		activity.forget(entry.Hash)
+	}
+}
+
+// torrentSet changes labels, and which files are downloaded locally, and in what order.  Other
+// settings are accepted but ignored, as put.io handles the torrent itself.
+func (receiver *RPCRequest) torrentSet() error {
+	entries, err := receiver.torrents()
+	if err != nil {
+		return err
+	}
+	labels, setLabels, err := receiver.labelsArg()
+	if err != nil {
+		return err
+	}
+	files, err := receiver.fileSelection()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if setLabels && !reflect.DeepEqual(labels, entry.Labels) {
+			if err := Downloader.Registry.Update(entry.Hash, func(e *registry.Entry) { e.Labels = labels }); err != nil {
+				return err
+			}
+		}
+		// Restarting interrupts the local download, so only when it matters.
+		if !files.present || !files.changes(entry) {
+			continue
+		}
+		if err := Downloader.Registry.Update(entry.Hash, files.apply); err != nil {
+			return err
+		}
+		Downloader.FilesChanged(entry.Hash)
+	}
+	return nil
+}
+
+// queueMove reorders the local download queue.
+func (receiver *RPCRequest) queueMove(move string) error {
+	entries, err := receiver.torrents()
+	if err != nil {
+		return err
+	}
+	hashes := make([]string, len(entries))
+	for i, entry := range entries {
+		hashes[i] = entry.Hash
+	}
+	if err := Downloader.Registry.MoveInQueue(hashes, move); err != nil {
+		return err
+	}
+	Downloader.QueueChanged()
+	return nil
+}
+
+func (receiver *RPCRequest) torrentRemove() error {
+	deleteLocalData, err := receiver.boolArg("delete-local-data")
+	if err != nil {
+		return err
+	}
+	toRemove, err := receiver.torrents()
+	if err != nil {
+		return err
+	}
+	for _, entry := range toRemove {
+		log.Printf("Removing torrent %d %s, delete-local-data %t", entry.ID, entry.Name, deleteLocalData)
+		if err := Downloader.Remove(entry, deleteLocalData); err != nil {
+			return err
 		}
-		body, err := ioutil.ReadAll(resp.Body)
-		defer resp.Body.Close()
+		activity.forget(entry.Hash)
+	}
+	return nil
+}
+
+func (receiver *RPCRequest) freeSpace() (*FreeSpace, error) {
+	path, err := receiver.stringArg("path", true)
+	if err != nil {
+		return nil, err
+	}
+	free, total, err := diskSpace(path)
+	if err != nil {
+		return nil, err
+	}
+	result := &FreeSpace{
+		Path:      path,
+		SizeBytes: free,
+		TotalSize: total,
+	}
+	if config.GetBool("putioFreeSpace") {
+		// Everything passes through put.io, so it can be the tighter limit.
+		putioFree, err := Downloader.FreeSpace()
 		if err != nil {
-			log.Printf("Error reading torrent response: %s", err.Error())
-			return 0, ""
+			return nil, err
+		}
+		if putioFree < result.SizeBytes {
+			result.SizeBytes = putioFree
 		}
-		info, err := metainfo.Load(bytes.NewBuffer(body))
+	}
+	return result, nil
+}
+
+// eachTorrent applies action to every torrent selected by "ids".
+func (receiver *RPCRequest) eachTorrent(action func(registry.Entry) error) error {
+	entries, err := receiver.torrents()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := action(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (receiver *RPCRequest) torrentSetLocation() error {
+	location, err := receiver.stringArg("location", true)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(location) {
+		return errors.New("new location path is not absolute")
+	}
+	location = filepath.Clean(location)
+	move, err := receiver.boolArg("move")
+	if err != nil {
+		return err
+	}
+	return receiver.eachTorrent(func(entry registry.Entry) error {
+		log.Printf("Setting location of %s to %s, move %t", entry.Name, location, move)
+		return Downloader.SetLocation(entry, location, move)
+	})
+}
+
+func (receiver *RPCRequest) torrentRenamePath() (*TorrentRenamePath, error) {
+	entries, err := receiver.torrents()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) != 1 {
+		return nil, errors.New("torrent-rename-path requires 1 torrent")
+	}
+	oldPath, err := receiver.stringArg("path", true)
+	if err != nil {
+		return nil, err
+	}
+	name, err := receiver.stringArg("name", true)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Renaming %s in %s to %s", oldPath, entries[0].Name, name)
+	if err := Downloader.RenamePath(entries[0], oldPath, name); err != nil {
+		return nil, err
+	}
+	return &TorrentRenamePath{Path: oldPath, Name: name, ID: entries[0].ID}, nil
+}
+
+// transferEntry returns the registry entry for a put.io transfer, registering
+// transfers which were added outside of transmissio.
+func transferEntry(transfer putio.Transfer) (registry.Entry, error) {
+	if entry, ok := Downloader.Registry.ByTransferID(transfer.ID); ok {
+		return entry, nil
+	}
+	var hash string
+	if strings.HasPrefix(transfer.Source, "magnet:") {
+		mi, err := metainfo.ParseMagnetURI(transfer.Source)
 		if err != nil {
-			log.Printf("Error parsing torrent response: %s", err.Error())
-			log.Printf("Torrent response: %s", string(body))
-			return 0, ""
+			return registry.Entry{}, err
+		}
+		hash = mi.InfoHash.HexString()
+	} else {
+		hash = torrentLinkToHash(transfer.Source)
+		if hash == "" {
+			return registry.Entry{}, fmt.Errorf("unable to derive hash for %s", transfer.Source)
+		}
+		log.Printf("No magnet URI, fetched and derived %s", hash)
+	}
+	entry, added, err := Downloader.Registry.Add(hash, transfer.Name, transfer.Source, config.GetString("downloadTo"))
+	if err != nil {
+		return entry, err
+	}
+	err = Downloader.Registry.Update(hash, func(e *registry.Entry) {
+		e.TransferID = transfer.ID
+		// Not ours to download until a client starts it.
+		e.External = e.External || added
+		if e.Name == "" {
+			e.Name = transfer.Name
+		}
+	})
+	entry, _ = Downloader.Registry.ByHash(hash)
+	return entry, err
+}
+
+// addOptions reads torrent-add's paused, labels and bandwidthPriority.
+func (receiver *RPCRequest) addOptions() (torrent.AddOptions, error) {
+	options := torrent.AddOptions{Paused: !config.GetBool("startAddedTorrents")}
+	var err error
+	if _, ok := receiver.Arguments["paused"]; ok {
+		if options.Paused, err = receiver.boolArg("paused"); err != nil {
+			return options, err
+		}
+	}
+	if options.Labels, _, err = receiver.labelsArg(); err != nil {
+		return options, err
+	}
+	if options.BandwidthPriority, _, err = receiver.intArg("bandwidthPriority"); err != nil {
+		return options, err
+	}
+	if options.BandwidthPriority < -1 || options.BandwidthPriority > 1 {
+		return options, fmt.Errorf("invalid bandwidthPriority %d", options.BandwidthPriority)
+	}
+	files, err := receiver.fileSelection()
+	if err != nil {
+		return options, err
+	}
+	var entry registry.Entry
+	files.apply(&entry)
+	options.Unwanted = entry.Unwanted
+	options.Priorities = entry.Priorities
+	return options, nil
+}
+
+// metainfoToMagnetLink converts the contents of a .torrent file.
+func metainfoToMagnetLink(data []byte) (string, error) {
+	mi, err := metainfo.Load(bytes.NewBuffer(data))
+	if err != nil {
+		log.Printf("error loading torrent: %s", err.Error())
+		return "", errInvalidTorrent
+	}
+	info, err := mi.UnmarshalInfo()
+	if err != nil {
+		log.Printf("error converting torrent: %s", err.Error())
+		return "", errInvalidTorrent
+	}
+	return mi.Magnet(info.Name, mi.HashInfoBytes()).String(), nil
+}
+
+// Biggest .torrent file fetchTorrentURL will read.
+const maxTorrentFileSize = 10 << 20
+
+var torrentURLClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		// Indexers may redirect to a magnet link, which we take as is.
+		if req.URL.Scheme == "magnet" {
+			return http.ErrUseLastResponse
 		}
-		torrentLinkInfoCache[torrentLink] = info
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	},
+}
+
+// fetchTorrentURL downloads a .torrent file, sending cookies as the Cookie
+// header, and returns its magnet link.
+func fetchTorrentURL(torrentURL, cookies string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, torrentURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if cookies != "" {
+		req.Header.Set("Cookie", cookies)
+	}
+	log.Printf("Retrieving torrent %s", torrentURL)
+	resp, err := torrentURLClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve torrent: %s", err.Error())
+	}
+	defer resp.Body.Close()
+	if location := resp.Header.Get("Location"); strings.HasPrefix(location, "magnet:") {
+		return location, nil
 	}
-	hashInfo := torrentLinkInfoCache[torrentLink].HashInfoBytes()
-	h := fnv.New32a()
-	if _, err := h.Write(hashInfo.Bytes()); err != nil {
-		log.Printf("Unable to make filename into ID, %s\n", err.Error())
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to retrieve torrent: %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTorrentFileSize))
+	if err != nil {
+		return "", fmt.Errorf("unable to retrieve torrent: %s", err.Error())
 	}
-	id := int64(h.Sum32())
-	hash := strings.ToLower(hashInfo.HexString())
-	return id, hash
+	return metainfoToMagnetLink(body)
+}
 
+// torrentLinkHashes caches the infohashes of torrent links, which take a
+// download to work out, as torrent-get looks them up on every call.
+var torrentLinkHashes struct {
+	sync.Mutex
+	byLink map[string]string
+}
+
+func torrentLinkToHash(torrentLink string) string {
+	torrentLinkHashes.Lock()
+	hash, ok := torrentLinkHashes.byLink[torrentLink]
+	torrentLinkHashes.Unlock()
+	if ok {
+		return hash
+	}
+	log.Printf("Retrieving torrent %s", torrentLink)
+	resp, err := http.Get(torrentLink) //nolint:gosec
+	if err != nil {
+		log.Printf("Error retrieving torrent link: %s", err.Error())
+		return ""
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+	if err != nil {
+		log.Printf("Error reading torrent response: %s", err.Error())
+		return ""
+	}
+	info, err := metainfo.Load(bytes.NewBuffer(body))
+	if err != nil {
+		log.Printf("Error parsing torrent response: %s", err.Error())
+		log.Printf("Torrent response: %s", string(body))
+		return ""
+	}
+	hash = strings.ToLower(info.HashInfoBytes().HexString())
+	torrentLinkHashes.Lock()
+	defer torrentLinkHashes.Unlock()
+	// TODO Smarter max cache size.
+	if torrentLinkHashes.byLink == nil || len(torrentLinkHashes.byLink) > 1000 {
+		torrentLinkHashes.byLink = make(map[string]string)
+	}
+	torrentLinkHashes.byLink[torrentLink] = hash
+	return hash
+}
+
+// redactedArguments hold tracker credentials, or are too big to log.
+var redactedArguments = []string{"cookies", "metainfo"}
+
+// redactRequest is a request body, legacy or JSON-RPC, fit for the log.
+func redactRequest(body []byte) string {
+	var requests []map[string]interface{}
+	batch := json.Unmarshal(body, &requests) == nil
+	if !batch {
+		var request map[string]interface{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return fmt.Sprintf("(unparseable, %d bytes)", len(body))
+		}
+		requests = []map[string]interface{}{request}
+	}
+	for _, request := range requests {
+		for _, key := range []string{"arguments", "params"} {
+			arguments, ok := request[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, name := range redactedArguments {
+				if _, ok := arguments[name]; ok {
+					arguments[name] = "(redacted)"
+				}
+			}
+		}
+	}
+	var redacted []byte
+	if batch {
+		redacted, _ = json.Marshal(requests)
+	} else {
+		redacted, _ = json.Marshal(requests[0])
+	}
+	return string(redacted)
 }
 
 func RPCHandler(w http.ResponseWriter, r *http.Request) {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+	// Before reading the body, so rejected clients can't fill memory or the log.
+	if !authorize(w, r, client) {
+		return
+	}
 	requestBytes, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Request: %s", string(requestBytes))
-	sessionID := r.Header.Get(sessionIDHeader)
-	if len(sessionID) == 0 {
-		w.Header().Set(sessionIDHeader, knownSessionID)
-		w.WriteHeader(http.StatusConflict)
+	log.Printf("Request: %s", redactRequest(requestBytes))
+	if !validSessionID(r.Header.Get(sessionIDHeader)) {
+		w.Header().Set(sessionIDHeader, currentSessionID())
+		http.Error(w, "Invalid or missing "+sessionIDHeader+" header.", http.StatusConflict)
 		return
 	}
 	if r.Method != http.MethodPost {
@@ -351,21 +849,36 @@ func RPCHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var rpcRequest RPCRequest
-	if err := json.Unmarshal(requestBytes, &rpcRequest); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	response, err := rpcRequest.DoIt()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	responseBytes, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	var responseBytes []byte
+	if isJSONRPC(requestBytes) {
+		responseBytes, err = handleJSONRPC(requestBytes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if responseBytes == nil {
+			// Only notifications, which get no response.
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	} else {
+		var rpcRequest RPCRequest
+		if err := json.Unmarshal(requestBytes, &rpcRequest); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response, err := rpcRequest.DoIt()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		responseBytes, err = json.Marshal(response)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
+	w.Header().Set("Content-Type", "application/json")
 	log.Printf("Response: %s", string(responseBytes))
 	if _, err := w.Write(responseBytes); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,327 @@
+package transmission
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/anonfunc/transmissio/internal/pkg/torrent"
+	"github.com/igungor/go-putio/putio"
+)
+
+// testMetainfo is a bencoded .torrent file, and its infohash.
+func testMetainfo(t *testing.T) ([]byte, string) {
+	infoBytes, err := bencode.Marshal(metainfo.Info{Name: "Show", PieceLength: 16384, Pieces: make([]byte, 20), Length: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi := metainfo.MetaInfo{InfoBytes: infoBytes, Announce: "http://tracker.example.com/announce"}
+	var buf bytes.Buffer
+	if err := mi.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), mi.HashInfoBytes().HexString()
+}
+
+func Test_fetchTorrentURL(t *testing.T) {
+	const magnet = "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	torrentFile, infoHash := testMetainfo(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/show.torrent":
+			if cookie, err := r.Cookie("uid"); err != nil || cookie.Value != "42" {
+				http.Error(w, "log in first", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-bittorrent")
+			_, _ = w.Write(torrentFile)
+		case "/corrupt.torrent":
+			_, _ = w.Write([]byte("<html>Not a torrent</html>"))
+		case "/private.torrent":
+			if cookie, err := r.Cookie("uid"); err != nil || cookie.Value != "42" {
+				http.Error(w, "log in first", http.StatusForbidden)
+				return
+			}
+			http.Redirect(w, r, magnet, http.StatusFound)
+		case "/redirect.torrent":
+			http.Redirect(w, r, "/private.torrent", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	tests := []struct {
+		name     string
+		path     string
+		cookies  string
+		want     string
+		wantHash string // Instead of want, for converted .torrent files.
+		wantErr  error  // Beyond any error, when set.
+		fails    bool
+	}{
+		{name: "Torrent file", path: "/show.torrent", cookies: "uid=42", wantHash: infoHash},
+		{name: "Corrupt torrent file", path: "/corrupt.torrent", wantErr: errInvalidTorrent, fails: true},
+		{name: "Magnet redirect", path: "/private.torrent", cookies: "uid=42; pass=abc", want: magnet},
+		{name: "Cookies kept across redirects", path: "/redirect.torrent", cookies: "uid=42", want: magnet},
+		{name: "Missing cookies", path: "/private.torrent", fails: true},
+		{name: "Not found", path: "/missing.torrent", fails: true},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetchTorrentURL(server.URL+tt.path, tt.cookies)
+			if (err != nil) != tt.fails || tt.wantErr != nil && err != tt.wantErr {
+				t.Fatalf("fetchTorrentURL() error = %v, want failure %v (%v)", err, tt.fails, tt.wantErr)
+			}
+			if tt.wantHash == "" {
+				if got != tt.want {
+					t.Errorf("fetchTorrentURL() = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			mi, err := metainfo.ParseMagnetURI(got)
+			if err != nil {
+				t.Fatalf("fetchTorrentURL() = %v, not a magnet link: %v", got, err)
+			}
+			if hash := mi.InfoHash.HexString(); hash != tt.wantHash {
+				t.Errorf("fetchTorrentURL() infohash = %s, want %s", hash, tt.wantHash)
+			}
+		})
+	}
+}
+
+// useTestDownloader points Downloader at one with a registry in a temporary
+// directory, returning the registry and directory.  It makes no put.io calls
+// as long as entries have no transfer or file ID.  cleanup restores Downloader.
+func useTestDownloader(t *testing.T) (reg *registry.Registry, dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "transmission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err = registry.Open(filepath.Join(dir, "registry.json"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	previous := Downloader
+	Downloader = &torrent.PutIoDownloader{Registry: reg}
+	return reg, dir, func() {
+		Downloader = previous
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRPCRequest_torrentRemove_unknownIDs(t *testing.T) {
+	reg, dir, cleanup := useTestDownloader(t)
+	defer cleanup()
+	known, _, err := reg.Add("c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "known", "magnet:?a", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ids  []interface{}
+	}{
+		{"Unknown ID", []interface{}{float64(known.ID + 1)}},
+		{"Unknown hash", []interface{}{"0000000000000000000000000000000000000000"}},
+		{"Known and unknown", []interface{}{float64(known.ID), float64(known.ID + 1)}},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			request := &RPCRequest{Method: "torrent-remove", Arguments: map[string]interface{}{
+				"ids":               tt.ids,
+				"delete-local-data": true,
+			}}
+			if err := request.torrentRemove(); err == nil {
+				t.Error("torrentRemove() error = nil, want an error")
+			}
+			if _, ok := reg.ByID(known.ID); !ok {
+				t.Error("torrentRemove() removed a known torrent alongside an unknown one")
+			}
+		})
+	}
+}
+
+func TestRPCRequest_torrentSet_unchangedFiles(t *testing.T) {
+	reg, dir, cleanup := useTestDownloader(t)
+	defer cleanup()
+	entry, _, err := reg.Add("c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "Show", "magnet:?a", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reg.Update(entry.Hash, func(e *registry.Entry) {
+		e.Unwanted = []int{1}
+		e.Priorities = map[int]int64{0: 1}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryFile := filepath.Join(dir, "registry.json")
+
+	tests := []struct {
+		name      string
+		arguments map[string]interface{}
+	}{
+		{"Other settings only", map[string]interface{}{"seedRatioLimit": 2.0, "bandwidthPriority": 1.0}},
+		{"Already wanted", map[string]interface{}{"files-wanted": []interface{}{0.0}}},
+		{"Already unwanted", map[string]interface{}{"files-unwanted": []interface{}{1.0}}},
+		{"Same priorities", map[string]interface{}{"priority-high": []interface{}{0.0}, "priority-normal": []interface{}{2.0}}},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Remove(registryFile); err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				// Put it back for the next test, whatever happened.
+				if err := reg.Update(entry.Hash, func(*registry.Entry) {}); err != nil {
+					t.Fatal(err)
+				}
+			}()
+			tt.arguments["ids"] = []interface{}{float64(entry.ID)}
+			request := &RPCRequest{Method: "torrent-set", Arguments: tt.arguments}
+			if err := request.torrentSet(); err != nil {
+				t.Fatalf("torrentSet() error = %v", err)
+			}
+			if _, err := os.Stat(registryFile); err == nil {
+				t.Error("torrentSet() updated the torrent when its files didn't change")
+			}
+		})
+	}
+}
+
+func TestRPCRequest_torrentSet_labels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  interface{}
+		want    []string
+		wantErr bool
+	}{
+		{name: "Labels", labels: []interface{}{"tv", " hd "}, want: []string{"tv", "hd"}},
+		{name: "Cleared", labels: []interface{}{}, want: nil},
+		{name: "Empty label", labels: []interface{}{"tv", " "}, wantErr: true},
+		{name: "Comma", labels: []interface{}{"tv,hd"}, wantErr: true},
+		{name: "Not a list", labels: "tv", wantErr: true},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			reg, dir, cleanup := useTestDownloader(t)
+			defer cleanup()
+			entry, _, err := reg.Add("c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "Show", "magnet:?a", dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := reg.Update(entry.Hash, func(e *registry.Entry) { e.Labels = []string{"old"} }); err != nil {
+				t.Fatal(err)
+			}
+			request := &RPCRequest{Method: "torrent-set", Arguments: map[string]interface{}{
+				"ids":    []interface{}{float64(entry.ID)},
+				"labels": tt.labels,
+			}}
+			err = request.torrentSet()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("torrentSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			want := tt.want
+			if tt.wantErr {
+				want = []string{"old"}
+			}
+			if got, _ := reg.ByHash(entry.Hash); !reflect.DeepEqual(got.Labels, want) {
+				t.Errorf("labels = %q, want %q", got.Labels, want)
+			}
+		})
+	}
+}
+
+func TestRPCRequest_DoIt_arguments(t *testing.T) {
+	tests := []struct {
+		name    string
+		request RPCRequest
+	}{
+		{"Failed with a typed nil", RPCRequest{Method: "free-space", Arguments: map[string]interface{}{}}},
+		{"Unknown method", RPCRequest{Method: "no-such-method"}},
+		{"No arguments", RPCRequest{}},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := tt.request.DoIt()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), `"arguments":{}`) {
+				t.Errorf("DoIt() = %s, want empty arguments", data)
+			}
+		})
+	}
+}
+
+func Test_redactRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"Legacy", `{"method":"torrent-add","arguments":{"filename":"https://x/a.torrent","cookies":"uid=42; pass=abc"}}`,
+			`{"arguments":{"cookies":"(redacted)","filename":"https://x/a.torrent"},"method":"torrent-add"}`},
+		{"JSON-RPC batch", `[{"jsonrpc":"2.0","method":"torrent_add","params":{"metainfo":"ZDQ6"},"id":1}]`,
+			`[{"id":1,"jsonrpc":"2.0","method":"torrent_add","params":{"metainfo":"(redacted)"}}]`},
+		{"Nothing to redact", `{"method":"session-get"}`, `{"method":"session-get"}`},
+		{"Unparseable", `{"cookies":`, `(unparseable, 11 bytes)`},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactRequest([]byte(tt.body)); got != tt.want {
+				t.Errorf("redactRequest() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_unwantedFiles(t *testing.T) {
+	files := []putio.File{{Name: "Show/e01.mkv", Size: 600}, {Name: "Show/sample.mkv", Size: 100}, {Name: "Show/e02.mkv", Size: 300}}
+	state := torrentState{size: 1000, have: 500}
+	tests := []struct {
+		name               string
+		unwanted           []int
+		wantSize           int64
+		wantBytesCompleted []int64
+	}{
+		{"All wanted", nil, 1000, []int64{300, 50, 150}},
+		{"Sample unwanted", []int{1}, 900, []int64{300, 0, 150}},
+		{"None wanted", []int{0, 1, 2}, 0, []int64{0, 0, 0}},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			entry := registry.Entry{Unwanted: tt.unwanted}
+			if got := wantedSize(entry, files, state.size); got != tt.wantSize {
+				t.Errorf("wantedSize() = %d, want %d", got, tt.wantSize)
+			}
+			for i, f := range files {
+				if got := bytesCompleted(entry, i, f, state); got != tt.wantBytesCompleted[i] {
+					t.Errorf("bytesCompleted(%d) = %d, want %d", i, got, tt.wantBytesCompleted[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package transmission
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+)
+
+// Transmission counts a torrent as recently active for a minute after it last did anything.
+const recentlyActiveWindow = 60 * time.Second
+
+// idSelector is the parsed "ids" argument.
+// https://github.com/transmission/transmission/blob/2.9x/extras/rpc-spec.txt#L90
+type idSelector struct {
+	all            bool
+	recentlyActive bool
+	ids            []interface{} // float64 IDs and string hashes, as given.
+}
+
+func parseIDs(arguments map[string]interface{}) (idSelector, error) {
+	raw, given := arguments["ids"]
+	if !given {
+		return idSelector{all: true}, nil
+	}
+	switch v := raw.(type) {
+	case float64:
+		return idSelector{ids: []interface{}{v}}, nil
+	case string:
+		if v == "recently-active" {
+			return idSelector{recentlyActive: true}, nil
+		}
+		return idSelector{ids: []interface{}{v}}, nil
+	case []interface{}:
+		for _, id := range v {
+			switch id.(type) {
+			case float64, string:
+			default:
+				return idSelector{}, fmt.Errorf("invalid id %v", id)
+			}
+		}
+		return idSelector{ids: v}, nil
+	default:
+		return idSelector{}, fmt.Errorf("invalid ids %v", raw)
+	}
+}
+
+func (s idSelector) matches(entry registry.Entry) bool {
+	switch {
+	case s.all:
+		return true
+	case s.recentlyActive:
+		return activity.recentlyActive(entry.Hash)
+	}
+	for _, id := range s.ids {
+		if idMatches(id, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func idMatches(id interface{}, entry registry.Entry) bool {
+	switch v := id.(type) {
+	case float64:
+		return int64(v) == entry.ID
+	case string:
+		return strings.EqualFold(v, entry.Hash)
+	}
+	return false
+}
+
+// lookup resolves explicitly listed ids against the registry, failing on any
+// which are unknown.  Selectors without explicit ids return every entry they match.
+func (s idSelector) lookup() ([]registry.Entry, error) {
+	if s.all || s.recentlyActive {
+		var result []registry.Entry
+		for _, entry := range Downloader.Registry.Entries() {
+			if s.matches(entry) {
+				result = append(result, entry)
+			}
+		}
+		return result, nil
+	}
+	result := make([]registry.Entry, 0, len(s.ids))
+	for _, id := range s.ids {
+		var entry registry.Entry
+		var found bool
+		switch v := id.(type) {
+		case float64:
+			entry, found = Downloader.Registry.ByID(int64(v))
+		case string:
+			entry, found = Downloader.Registry.ByHash(v)
+		}
+		if !found {
+			return nil, fmt.Errorf("torrent %v not found", id)
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+type activitySample struct {
+	status     int64
+	downloaded int64
+	at         time.Time
+}
+
+// activityTracker remembers when torrents last changed.
+type activityTracker struct {
+	mu       sync.Mutex
+	torrents map[string]activitySample // By hash.
+}
+
+var activity = &activityTracker{
+	torrents: make(map[string]activitySample),
+}
+
+// observe records the current state of a torrent.  Queued and downloading
+// torrents are always active; others are active for a while after they change.
+func (a *activityTracker) observe(hash string, status, downloaded int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	previous, seen := a.torrents[hash]
+	if seen && previous.status == status && previous.downloaded == downloaded && status != statusDownloadWait && status != statusDownload {
+		return
+	}
+	a.torrents[hash] = activitySample{status: status, downloaded: downloaded, at: time.Now()}
+}
+
+func (a *activityTracker) recentlyActive(hash string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sample, seen := a.torrents[hash]
+	return !seen || time.Since(sample.at) < recentlyActiveWindow
+}
+
+// removed returns the torrents removed within the recently active window.
+// Clients can't be told apart, as Sonarr and Radarr may share a host, so each
+// sees every recent removal; one seen twice is simply not in torrents.
+func (a *activityTracker) removed() []int64 {
+	return Downloader.Registry.RemovedSince(time.Now().Add(-recentlyActiveWindow))
+}
+
+func (a *activityTracker) forget(hash string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.torrents, hash)
+}
@@ -0,0 +1,67 @@
+package transmission
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+)
+
+func Test_idSelector_matches(t *testing.T) {
+	entry := registry.Entry{ID: 7, Hash: "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"}
+	tests := []struct {
+		name    string
+		args    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "No ids", args: `{}`, want: true},
+		{name: "Single number", args: `{"ids": 7}`, want: true},
+		{name: "Single other number", args: `{"ids": 8}`, want: false},
+		{name: "Array of numbers", args: `{"ids": [1, 7]}`, want: true},
+		{name: "Array without match", args: `{"ids": [1, 2]}`, want: false},
+		{name: "Hash", args: `{"ids": ["C12FE1C06BBA254A9DC9F519B335AA7C1367A88A"]}`, want: true},
+		{name: "Mixed", args: `{"ids": [3, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"]}`, want: true},
+		{name: "Empty array", args: `{"ids": []}`, want: false},
+		{name: "Bad type", args: `{"ids": {"id": 7}}`, wantErr: true},
+		{name: "Bad element", args: `{"ids": [true]}`, wantErr: true},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			var arguments map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.args), &arguments); err != nil {
+				t.Fatal(err)
+			}
+			selector, err := parseIDs(arguments)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseIDs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := selector.matches(entry); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_activityTracker_removed(t *testing.T) {
+	reg, dir, cleanup := useTestDownloader(t)
+	defer cleanup()
+	entry, _, err := reg.Add("c12fe1c06bba254a9dc9f519b335aa7c1367a88a", "Show", "magnet:?a", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Remove(entry.Hash); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sonarr and Radarr on one host must both hear of the removal.
+	for i := 0; i < 2; i++ {
+		if removed := activity.removed(); len(removed) != 1 || removed[0] != entry.ID {
+			t.Errorf("removed() call %d = %v, want [%d]", i+1, removed, entry.ID)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package transmission
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+const jsonRPCVersion = "2.0"
+
+// JSON-RPC 2.0 error codes.
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCServerError    = -32000 // Anything Transmission reports in the result string.
+)
+
+// camelCaseArguments are the request arguments which, unlike the rest, are
+// camelCase rather than kebab-case in the legacy protocol.
+var camelCaseArguments = map[string]bool{
+	"bandwidthPriority":   true,
+	"downloadLimit":       true,
+	"downloadLimited":     true,
+	"honorsSessionLimits": true,
+	"queuePosition":       true,
+	"seedIdleLimit":       true,
+	"seedIdleMode":        true,
+	"seedRatioLimit":      true,
+	"seedRatioMode":       true,
+	"trackerAdd":          true,
+	"trackerList":         true,
+	"trackerRemove":       true,
+	"trackerReplace":      true,
+	"uploadLimit":         true,
+	"uploadLimited":       true,
+}
+
+// isJSONRPC reports whether a request body is JSON-RPC 2.0, either a batch or
+// a single request, rather than the legacy protocol.
+func isJSONRPC(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		return true
+	}
+	var probe struct {
+		JSONRPC string `json:"jsonrpc"`
+	}
+	return json.Unmarshal(body, &probe) == nil && probe.JSONRPC != ""
+}
+
+// handleJSONRPC answers a JSON-RPC 2.0 request or batch, returning nil when
+// there is nothing to answer as it was all notifications.
+func handleJSONRPC(body []byte) ([]byte, error) {
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		return json.Marshal(jsonRPCError(nil, jsonRPCParseError, "parse error"))
+	}
+	if body[0] != '[' {
+		response := callJSONRPC(body)
+		if response == nil {
+			return nil, nil
+		}
+		return json.Marshal(response)
+	}
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+		return json.Marshal(jsonRPCError(nil, jsonRPCInvalidRequest, "invalid request"))
+	}
+	var responses []*JSONRPCResponse
+	for _, raw := range batch {
+		if response := callJSONRPC(raw); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(responses)
+}
+
+// callJSONRPC runs a single request through the legacy handlers, translating
+// names on the way in and out.
+func callJSONRPC(raw json.RawMessage) *JSONRPCResponse {
+	var request JSONRPCRequest
+	if err := json.Unmarshal(raw, &request); err != nil {
+		return jsonRPCError(nil, jsonRPCInvalidRequest, "invalid request")
+	}
+	if request.JSONRPC != jsonRPCVersion || request.Method == "" {
+		return jsonRPCError(request.ID, jsonRPCInvalidRequest, "invalid request")
+	}
+	legacy := RPCRequest{
+		Method:    strings.Replace(request.Method, "_", "-", -1),
+		Arguments: legacyArguments(request.Params),
+		snakeCase: true,
+	}
+	arguments, err := legacy.call()
+	if len(request.ID) == 0 {
+		return nil
+	}
+	switch {
+	case err == errMethodNotRecognized:
+		return jsonRPCError(request.ID, jsonRPCMethodNotFound, err.Error())
+	case err != nil:
+		return jsonRPCError(request.ID, jsonRPCServerError, err.Error())
+	}
+	result, err := snakeCaseKeys(arguments)
+	if err != nil {
+		return jsonRPCError(request.ID, jsonRPCServerError, err.Error())
+	}
+	if result == nil {
+		result = map[string]interface{}{}
+	}
+	return &JSONRPCResponse{JSONRPC: jsonRPCVersion, Result: result, ID: request.ID}
+}
+
+func jsonRPCError(id json.RawMessage, code int, message string) *JSONRPCResponse {
+	return &JSONRPCResponse{
+		JSONRPC: jsonRPCVersion,
+		Error:   &JSONRPCError{Code: code, Message: message},
+		ID:      id,
+	}
+}
+
+// legacyArguments renames snake_case params, and torrent-get's fields, to
+// what the legacy handlers expect.
+func legacyArguments(params map[string]interface{}) map[string]interface{} {
+	arguments := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		name := legacyName(key, camelCaseArguments)
+		switch name {
+		case "fields":
+			if fields, ok := value.([]interface{}); ok {
+				renamed := make([]interface{}, len(fields))
+				for i, field := range fields {
+					if s, ok := field.(string); ok {
+						renamed[i] = legacyName(s, torrentInfoCamelCase)
+					} else {
+						renamed[i] = field
+					}
+				}
+				value = renamed
+			}
+		case "ids":
+			if value == "recently_active" {
+				value = "recently-active"
+			}
+		}
+		arguments[name] = value
+	}
+	return arguments
+}
+
+// torrentInfoCamelCase are the camelCase torrent-get fields.
+var torrentInfoCamelCase = func() map[string]bool {
+	names := make(map[string]bool)
+	for name := range torrentInfoFields {
+		if !strings.Contains(name, "-") {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// legacyName turns a snake_case name into camelCase if that's one of
+// camelCase, or kebab-case otherwise.
+func legacyName(name string, camelCase map[string]bool) string {
+	if !strings.Contains(name, "_") {
+		return name
+	}
+	words := strings.Split(name, "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	if camel := strings.Join(words, ""); camelCase[camel] {
+		return camel
+	}
+	return strings.Replace(name, "_", "-", -1)
+}
+
+// snakeCaseName turns a camelCase or kebab-case name into snake_case.
+func snakeCaseName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r == '-':
+			b.WriteRune('_')
+		case unicode.IsUpper(r):
+			b.WriteRune('_')
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// snakeCaseKeys returns the JSON form of v with every object key in snake_case.
+func snakeCaseKeys(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return renameKeys(generic), nil
+}
+
+func renameKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			renamed[snakeCaseName(key)] = renameKeys(value)
+		}
+		return renamed
+	case []interface{}:
+		for i, value := range v {
+			v[i] = renameKeys(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,96 @@
+package transmission
+
+import "testing"
+
+func Test_legacyName(t *testing.T) {
+	tests := []struct {
+		name      string
+		camelCase map[string]bool
+		want      string
+	}{
+		{name: "ids", camelCase: camelCaseArguments, want: "ids"},
+		{name: "download_dir", camelCase: camelCaseArguments, want: "download-dir"},
+		{name: "bandwidth_priority", camelCase: camelCaseArguments, want: "bandwidthPriority"},
+		{name: "percent_done", camelCase: torrentInfoCamelCase, want: "percentDone"},
+		{name: "download_dir", camelCase: torrentInfoCamelCase, want: "downloadDir"},
+		{name: "primary_mime_type", camelCase: torrentInfoCamelCase, want: "primary-mime-type"},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := legacyName(tt.name, tt.camelCase); got != tt.want {
+				t.Errorf("legacyName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_snakeCaseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "id", want: "id"},
+		{name: "percentDone", want: "percent_done"},
+		{name: "rpc-version-semver", want: "rpc_version_semver"},
+		{name: "total_size", want: "total_size"},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := snakeCaseName(tt.name); got != tt.want {
+				t.Errorf("snakeCaseName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_handleJSONRPC(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "Parse error",
+			body: `{"jsonrpc": "2.0", "method"`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`,
+		},
+		{
+			name: "Empty batch",
+			body: `[]`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}`,
+		},
+		{
+			name: "Unknown method",
+			body: `{"jsonrpc": "2.0", "method": "torrent_frobnicate", "id": 1}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32601,"message":"method name not recognized"},"id":1}`,
+		},
+		{
+			name: "Notification",
+			body: `{"jsonrpc": "2.0", "method": "torrent_frobnicate"}`,
+			want: ``,
+		},
+		{
+			name: "Batch",
+			body: `[{"jsonrpc": "2.0", "method": "torrent_frobnicate", "id": "a"}, {"jsonrpc": "2.0", "method": "torrent_frobnicate"}, 7]`,
+			want: `[{"jsonrpc":"2.0","error":{"code":-32601,"message":"method name not recognized"},"id":"a"},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}]`,
+		},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if !isJSONRPC([]byte(tt.body)) && tt.name != "Parse error" {
+				t.Errorf("isJSONRPC() = false")
+			}
+			got, err := handleJSONRPC([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("handleJSONRPC() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("handleJSONRPC() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package transmission
 
+import "encoding/json"
+
 type RPCRequest struct {
 	Method    string                 `json:"method"`
 	Arguments map[string]interface{} `json:"arguments"`
 	Tag       *int                   `json:"tag,omitempty"`
+
+	snakeCase bool // Field names are in JSON-RPC 2.0's snake_case.
 }
 
 type RPCResponse struct {
@@ -12,19 +16,74 @@ type RPCResponse struct {
 	Tag       *int        `json:"tag,omitempty"`
 }
 
+// JSONRPCRequest is a JSON-RPC 2.0 request, as sent by Transmission 4.1 and
+// later clients.  Requests without an ID are notifications, needing no response.
+type JSONRPCRequest struct {
+	JSONRPC string                 `json:"jsonrpc"`
+	Method  string                 `json:"method"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+	ID      json.RawMessage        `json:"id,omitempty"`
+}
+
+type JSONRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *JSONRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type JSONRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
 type SessionInfo struct {
-	Version               string `json:"version"`
-	RPCVersion            string `json:"rpc-version"`
-	RPCVersionMinimum     string `json:"rpc-version-minimum"`
-	SpeedLimitDown        int    `json:"speed-limit-down"`
-	SpeedLimitUp          int    `json:"speed-limit-up"`
-	SpeedLimitDownEnabled bool   `json:"speed-limit-down-enabled"`
-	SpeedLimitUpEnabled   bool   `json:"speed-limit-up-enabled"`
+	AltSpeedDown              int64        `json:"alt-speed-down"`
+	AltSpeedEnabled           bool         `json:"alt-speed-enabled"`
+	AltSpeedTimeBegin         int64        `json:"alt-speed-time-begin"`
+	AltSpeedTimeDay           int64        `json:"alt-speed-time-day"`
+	AltSpeedTimeEnabled       bool         `json:"alt-speed-time-enabled"`
+	AltSpeedTimeEnd           int64        `json:"alt-speed-time-end"`
+	AltSpeedUp                int64        `json:"alt-speed-up"`
+	ConfigDir                 string       `json:"config-dir"`
+	DownloadDir               string       `json:"download-dir"`
+	DownloadDirFreeSpace      int64        `json:"download-dir-free-space"`
+	DownloadQueueEnabled      bool         `json:"download-queue-enabled"`
+	DownloadQueueSize         int64        `json:"download-queue-size"`
+	IncompleteDir             string       `json:"incomplete-dir"`
+	IncompleteDirEnabled      bool         `json:"incomplete-dir-enabled"`
+	RenamePartialFiles        bool         `json:"rename-partial-files"`
+	RPCVersion                int64        `json:"rpc-version"`
+	RPCVersionMinimum         int64        `json:"rpc-version-minimum"`
+	RPCVersionSemver          string       `json:"rpc-version-semver"`
+	SeedQueueEnabled          bool         `json:"seed-queue-enabled"`
+	SeedQueueSize             int64        `json:"seed-queue-size"`
+	SessionID                 string       `json:"session-id"`
+	SpeedLimitDown            int64        `json:"speed-limit-down"`
+	SpeedLimitDownEnabled     bool         `json:"speed-limit-down-enabled"`
+	SpeedLimitUp              int64        `json:"speed-limit-up"`
+	SpeedLimitUpEnabled       bool         `json:"speed-limit-up-enabled"`
+	StartAddedTorrents        bool         `json:"start-added-torrents"`
+	TrashOriginalTorrentFiles bool         `json:"trash-original-torrent-files"`
+	Units                     SessionUnits `json:"units"`
+	Version                   string       `json:"version"`
+}
+
+type SessionUnits struct {
+	SpeedUnits  []string `json:"speed-units"`
+	SpeedBytes  int64    `json:"speed-bytes"`
+	SizeUnits   []string `json:"size-units"`
+	SizeBytes   int64    `json:"size-bytes"`
+	MemoryUnits []string `json:"memory-units"`
+	MemoryBytes int64    `json:"memory-bytes"`
 }
 
+// TorrentGet holds, in "objects" format, a map of the requested fields for each
+// torrent, and in "table" format a row of values for each torrent following a
+// row of field names.
 type TorrentGet struct {
-	Torrents []TorrentInfo `json:"torrents"`
-	Removed  []int         `json:"removed,omitempty"`
+	Torrents []interface{} `json:"torrents"`
+	Removed  []int64       `json:"removed,omitempty"`
 }
 
 type TorrentInfo struct {
@@ -41,16 +100,31 @@ type TorrentInfo struct {
 	PeersConnected     *int64 `json:"peersConnected,omitempty"`
 	Eta                int64  `json:"eta,omitempty"`
 	// HaveUnchecked      *int64      `json:"haveUnchecked,omitempty"`
-	HaveValid        *int64     `json:"haveValid,omitempty"`
-	UploadedEver     *int64     `json:"uploadedEver,omitempty"`
-	SizeWhenDone     int64      `json:"sizeWhenDone,omitempty"`
-	AddedDate        int64      `json:"addedDate,omitempty"`
-	DoneDate         int64      `json:"doneDate,omitempty"`
-	DesiredAvailable *int64     `json:"desiredAvailable,omitempty"`
-	Comment          string     `json:"comment,omitempty"`
-	PercentDone      float32    `json:"percentDone,omitempty"`
-	IsFinished       bool       `json:"isFinished,omitempty"`
-	Files            []FileInfo `json:"files,omitempty"`
+	HaveValid               *int64     `json:"haveValid,omitempty"`
+	UploadedEver            *int64     `json:"uploadedEver,omitempty"`
+	SizeWhenDone            int64      `json:"sizeWhenDone,omitempty"`
+	LeftUntilDone           *int64     `json:"leftUntilDone,omitempty"`
+	AddedDate               int64      `json:"addedDate,omitempty"`
+	DoneDate                int64      `json:"doneDate,omitempty"`
+	DesiredAvailable        *int64     `json:"desiredAvailable,omitempty"`
+	Comment                 string     `json:"comment,omitempty"`
+	PercentDone             float32    `json:"percentDone,omitempty"`
+	IsFinished              bool       `json:"isFinished,omitempty"`
+	Files                   []FileInfo `json:"files,omitempty"`
+	FileStats               []FileStat `json:"fileStats,omitempty"`
+	Wanted                  []bool     `json:"wanted,omitempty"`
+	Priorities              []int64    `json:"priorities,omitempty"`
+	BandwidthPriority       int64      `json:"bandwidthPriority,omitempty"`
+	QueuePosition           int64      `json:"queuePosition,omitempty"`
+	HashString              string     `json:"hashString,omitempty"`
+	MagnetLink              string     `json:"magnetLink,omitempty"`
+	TotalSize               int64      `json:"totalSize,omitempty"`
+	MetadataPercentComplete float32    `json:"metadataPercentComplete,omitempty"`
+	// Since RPC version 16.
+	Labels []string `json:"labels,omitempty"`
+	// Since RPC version 17.
+	FileCount       int64  `json:"file-count,omitempty"`
+	PrimaryMimeType string `json:"primary-mime-type,omitempty"`
 }
 
 type FileInfo struct {
@@ -59,6 +133,12 @@ type FileInfo struct {
 	Name           string `json:"name"`
 }
 
+type FileStat struct {
+	BytesCompleted int64 `json:"bytesCompleted"`
+	Wanted         bool  `json:"wanted"`
+	Priority       int64 `json:"priority"`
+}
+
 type TorrentInfoSmall struct {
 	ID         int64  `json:"id"`
 	Name       string `json:"name"`
@@ -69,3 +149,34 @@ type TorrentAdd struct {
 	TorrentAdded     *TorrentInfoSmall `json:"torrent-added,omitempty"`
 	TorrentDuplicate *TorrentInfoSmall `json:"torrent-duplicate,omitempty"`
 }
+
+type TorrentRenamePath struct {
+	Path string `json:"path"`
+	Name string `json:"name"`
+	ID   int64  `json:"id"`
+}
+
+type FreeSpace struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size-bytes"`
+	TotalSize int64  `json:"total_size"`
+}
+
+type SessionStats struct {
+	ActiveTorrentCount int64         `json:"activeTorrentCount"`
+	DownloadSpeed      int64         `json:"downloadSpeed"`
+	PausedTorrentCount int64         `json:"pausedTorrentCount"`
+	TorrentCount       int64         `json:"torrentCount"`
+	UploadSpeed        int64         `json:"uploadSpeed"`
+	CumulativeStats    StatsCounters `json:"cumulative-stats"`
+	CurrentStats       StatsCounters `json:"current-stats"`
+}
+
+type StatsCounters struct {
+	UploadedBytes     int64 `json:"uploadedBytes"`
+	DownloadedBytes   int64 `json:"downloadedBytes"`
+	FilesAdded        int64 `json:"filesAdded"`
+	TorrentsCompleted int64 `json:"torrentsCompleted"`
+	SessionCount      int64 `json:"sessionCount"`
+	SecondsActive     int64 `json:"secondsActive"`
+}
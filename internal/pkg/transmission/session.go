@@ -0,0 +1,177 @@
+package transmission
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+
+	"github.com/anonfunc/transmissio/internal/pkg/config"
+	"github.com/spf13/viper"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	version           = "3.00"
+	rpcVersion        = 16 // For torrent-get's table format, and labels.  Not 17, as there are no bandwidth groups.
+	rpcVersionMinimum = 1
+	rpcVersionSemver  = "5.2.0" // Semver equivalent of rpc-version 16, as 17 is 5.3.0.
+)
+
+type settingKind int
+
+const (
+	stringSetting settingKind = iota
+	intSetting
+	boolSetting
+)
+
+type sessionSetting struct {
+	key  string
+	kind settingKind
+}
+
+// sessionSettings are the session fields session-set may change, with the
+// config keys holding them.  Anything else is read-only, and ignored.
+var sessionSettings = map[string]sessionSetting{
+	"alt-speed-down":               {"altSpeedDown", intSetting},
+	"alt-speed-enabled":            {"altSpeedEnabled", boolSetting},
+	"alt-speed-time-begin":         {"altSpeedTimeBegin", intSetting},
+	"alt-speed-time-day":           {"altSpeedTimeDay", intSetting},
+	"alt-speed-time-enabled":       {"altSpeedTimeEnabled", boolSetting},
+	"alt-speed-time-end":           {"altSpeedTimeEnd", intSetting},
+	"alt-speed-up":                 {"altSpeedUp", intSetting},
+	"download-dir":                 {"downloadTo", stringSetting},
+	"download-queue-enabled":       {"downloadQueueEnabled", boolSetting},
+	"download-queue-size":          {"downloadQueueSize", intSetting},
+	"incomplete-dir":               {"incompleteDir", stringSetting},
+	"incomplete-dir-enabled":       {"incompleteDirEnabled", boolSetting},
+	"rename-partial-files":         {"renamePartialFiles", boolSetting},
+	"seed-queue-enabled":           {"seedQueueEnabled", boolSetting},
+	"seed-queue-size":              {"seedQueueSize", intSetting},
+	"speed-limit-down":             {"speedLimitDown", intSetting},
+	"speed-limit-down-enabled":     {"speedLimitDownEnabled", boolSetting},
+	"speed-limit-up":               {"speedLimitUp", intSetting},
+	"speed-limit-up-enabled":       {"speedLimitUpEnabled", boolSetting},
+	"start-added-torrents":         {"startAddedTorrents", boolSetting},
+	"trash-original-torrent-files": {"trashOriginalTorrentFiles", boolSetting},
+}
+
+func sessionGet() SessionInfo {
+	downloadDir := config.GetString("downloadTo")
+	freeSpace, _, err := diskSpace(downloadDir)
+	if err != nil {
+		log.Printf("Unable to get free space of %s: %s", downloadDir, err.Error())
+		freeSpace = -1
+	}
+	return SessionInfo{
+		AltSpeedDown:              config.GetInt64("altSpeedDown"),
+		AltSpeedEnabled:           config.GetBool("altSpeedEnabled"),
+		AltSpeedTimeBegin:         config.GetInt64("altSpeedTimeBegin"),
+		AltSpeedTimeDay:           config.GetInt64("altSpeedTimeDay"),
+		AltSpeedTimeEnabled:       config.GetBool("altSpeedTimeEnabled"),
+		AltSpeedTimeEnd:           config.GetInt64("altSpeedTimeEnd"),
+		AltSpeedUp:                config.GetInt64("altSpeedUp"),
+		ConfigDir:                 filepath.Dir(viper.ConfigFileUsed()),
+		DownloadDir:               downloadDir,
+		DownloadDirFreeSpace:      freeSpace,
+		DownloadQueueEnabled:      config.GetBool("downloadQueueEnabled"),
+		DownloadQueueSize:         config.GetInt64("downloadQueueSize"),
+		IncompleteDir:             config.GetString("incompleteDir"),
+		IncompleteDirEnabled:      config.GetBool("incompleteDirEnabled"),
+		RenamePartialFiles:        config.GetBool("renamePartialFiles"),
+		RPCVersion:                rpcVersion,
+		RPCVersionMinimum:         rpcVersionMinimum,
+		RPCVersionSemver:          rpcVersionSemver,
+		SeedQueueEnabled:          config.GetBool("seedQueueEnabled"),
+		SeedQueueSize:             config.GetInt64("seedQueueSize"),
+		SessionID:                 currentSessionID(),
+		SpeedLimitDown:            config.GetInt64("speedLimitDown"),
+		SpeedLimitDownEnabled:     config.GetBool("speedLimitDownEnabled"),
+		SpeedLimitUp:              config.GetInt64("speedLimitUp"),
+		SpeedLimitUpEnabled:       config.GetBool("speedLimitUpEnabled"),
+		StartAddedTorrents:        config.GetBool("startAddedTorrents"),
+		TrashOriginalTorrentFiles: config.GetBool("trashOriginalTorrentFiles"),
+		Units: SessionUnits{
+			SpeedUnits:  []string{"kB/s", "MB/s", "GB/s", "TB/s"},
+			SpeedBytes:  1000,
+			SizeUnits:   []string{"kB", "MB", "GB", "TB"},
+			SizeBytes:   1000,
+			MemoryUnits: []string{"KiB", "MiB", "GiB", "TiB"},
+			MemoryBytes: 1024,
+		},
+		Version: version,
+	}
+}
+
+// sessionSet checks every change before applying any, then saves them to the config file.
+func (receiver *RPCRequest) sessionSet() error {
+	changes := make(map[string]interface{})
+	for field := range receiver.Arguments {
+		setting, ok := sessionSettings[field]
+		if !ok {
+			log.Printf("Ignoring session-set of %s", field)
+			continue
+		}
+		var value interface{}
+		var err error
+		switch setting.kind {
+		case stringSetting:
+			value, err = receiver.stringArg(field, false)
+		case intSetting:
+			value, _, err = receiver.intArg(field)
+		case boolSetting:
+			value, err = receiver.boolArg(field)
+		}
+		if err != nil {
+			return err
+		}
+		if field == "download-dir" {
+			if !filepath.IsAbs(value.(string)) {
+				return errors.New("download directory path is not absolute")
+			}
+			value = filepath.Clean(value.(string))
+		}
+		changes[setting.key] = value
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	for key, value := range changes {
+		log.Printf("Setting %s to %v", key, value)
+	}
+	err := config.Set(changes)
+	// The download queue may have grown.
+	Downloader.QueueChanged()
+	if err != nil {
+		return fmt.Errorf("unable to save config: %s", err.Error())
+	}
+	return nil
+}
+
+func sessionStats() SessionStats {
+	result := SessionStats{
+		DownloadSpeed:   Downloader.Stats.DownloadRate(),
+		CumulativeStats: StatsCounters(Downloader.Stats.Cumulative()),
+		CurrentStats:    StatsCounters(Downloader.Stats.Current()),
+	}
+	for _, entry := range Downloader.Registry.Entries() {
+		result.TorrentCount++
+		switch {
+		case Downloader.Paused(entry.Hash):
+			result.PausedTorrentCount++
+		case Downloader.Active(entry.Hash):
+			result.ActiveTorrentCount++
+		}
+	}
+	return result
+}
+
+// diskSpace returns the free and total bytes of the filesystem holding path.
+func diskSpace(path string) (int64, int64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return int64(stat.Bavail * uint64(stat.Bsize)), int64(stat.Blocks * uint64(stat.Bsize)), nil
+}
@@ -0,0 +1,49 @@
+package transmission
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log"
+	"sync"
+	"time"
+)
+
+// sessionID is what clients must send in the X-Transmission-Session-Id
+// header, which a cross-site request can't learn, to guard against CSRF.
+var sessionID struct {
+	sync.Mutex
+	id string
+}
+
+func currentSessionID() string {
+	sessionID.Lock()
+	defer sessionID.Unlock()
+	return sessionID.id
+}
+
+// rotateSessionID replaces the session ID, so clients have to fetch the new
+// one from a 409 response.
+func rotateSessionID() {
+	sessionRandomBytes := make([]byte, 16)
+	_, err := rand.Read(sessionRandomBytes)
+	if err != nil {
+		panic(err)
+	}
+	sessionID.Lock()
+	defer sessionID.Unlock()
+	sessionID.id = base64.StdEncoding.EncodeToString(sessionRandomBytes)
+}
+
+func validSessionID(id string) bool {
+	current := currentSessionID()
+	return id != "" && subtle.ConstantTimeCompare([]byte(id), []byte(current)) == 1
+}
+
+func rotateSessionIDEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		log.Printf("Rotating session ID")
+		rotateSessionID()
+	}
+}
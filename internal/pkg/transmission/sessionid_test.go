@@ -0,0 +1,21 @@
+package transmission
+
+import "testing"
+
+func Test_validSessionID(t *testing.T) {
+	rotateSessionID()
+	first := currentSessionID()
+	if !validSessionID(first) {
+		t.Errorf("validSessionID(%q) = false for the current ID", first)
+	}
+	if validSessionID("") {
+		t.Error("validSessionID(\"\") = true")
+	}
+	rotateSessionID()
+	if validSessionID(first) {
+		t.Errorf("validSessionID(%q) = true after rotation", first)
+	}
+	if !validSessionID(currentSessionID()) {
+		t.Error("validSessionID() = false for the rotated ID")
+	}
+}
@@ -0,0 +1,124 @@
+package transmission
+
+import (
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/anonfunc/transmissio/internal/pkg/torrent"
+	"github.com/igungor/go-putio/putio"
+)
+
+// tr_torrent_activity
+const (
+	statusStopped      int64 = 0
+	statusCheckWait    int64 = 1
+	statusCheck        int64 = 2
+	statusDownloadWait int64 = 3
+	statusDownload     int64 = 4
+	statusSeedWait     int64 = 5
+	statusSeed         int64 = 6
+	statusUnknown      int64 = 7
+)
+
+// torrentState combines put.io's progress on a transfer with ours on the
+// local copy.  Both halves are weighted equally, so a torrent is only
+// finished once it is in the download directory.
+type torrentState struct {
+	status   int64
+	size     int64
+	have     int64
+	rate     int64
+	eta      int64
+	finished bool
+	// Transmission's error type and errorString.
+	errorCode   int64
+	errorString string
+}
+
+func (s torrentState) left() int64 {
+	return s.size - s.have
+}
+
+func (s torrentState) percentDone() float32 {
+	if s.size == 0 {
+		return 0
+	}
+	return float32(s.have) / float32(s.size)
+}
+
+// stateOf works out a torrent's state from its put.io transfer and, if it has
+// one, the progress of its job.  size is the transfer's size less any files
+// not wanted locally, which put.io's progress is scaled to.
+func stateOf(entry registry.Entry, transfer putio.Transfer, size int64, progress torrent.Progress, ok bool) torrentState {
+	state := torrentState{size: size, eta: -1}
+	putioDone := transfer.Status == "COMPLETED" || transfer.Status == "SEEDING"
+	switch {
+	case ok && progress.Phase == torrent.PhaseDone, !ok && !entry.DoneDate.IsZero(), entry.External && putioDone:
+		state.status = statusSeed
+		state.have = size
+		state.finished = true
+	case ok && progress.Phase == torrent.PhaseLocal:
+		state.status = statusDownload
+		// Only wanted files are downloaded locally, so that's the second half.
+		localSize := progress.LocalSize
+		if localSize <= 0 {
+			localSize = size
+		}
+		local := progress.LocalBytes
+		if local > localSize {
+			local = localSize
+		}
+		half := size / 2
+		if localSize > 0 {
+			state.have = half + int64(float64(size-half)*float64(local)/float64(localSize))
+		}
+		state.rate = progress.LocalRate
+		if state.rate > 0 {
+			state.eta = (localSize - local) / state.rate
+		}
+	case putioDone, ok && progress.Phase == torrent.PhaseWaiting:
+		// Waiting for the local download to start.
+		state.status = statusDownloadWait
+		state.have = size / 2
+	default:
+		switch transfer.Status {
+		case "IN_QUEUE", "WAITING", "PREPARING_DOWNLOAD":
+			state.status = statusDownloadWait
+		case "DOWNLOADING", "COMPLETING":
+			state.status = statusDownload
+		default:
+			state.status = statusUnknown
+		}
+		downloaded := transfer.Downloaded
+		if transfer.Size > 0 {
+			downloaded = int64(float64(downloaded) * float64(size) / float64(transfer.Size))
+		}
+		if entry.External {
+			state.have = downloaded
+		} else {
+			state.have = downloaded / 2
+		}
+		state.rate = int64(transfer.DownloadSpeed)
+		if transfer.EstimatedTime > 0 {
+			state.eta = transfer.EstimatedTime
+		}
+	}
+	switch {
+	case entry.Error != torrent.ErrorNone && !ok:
+		// Our fetch failed, which was put.io's fault or local.
+		state.errorCode = entry.Error
+		state.errorString = entry.ErrorString
+		entry.Paused = true
+	case transfer.Status == "ERROR":
+		state.errorCode = torrent.ErrorTrackerError
+		state.errorString = torrent.TransferErrorMessage(transfer)
+		entry.Paused = true
+	case transfer.ErrorMessage != "":
+		state.errorCode = torrent.ErrorTrackerWarning
+		state.errorString = transfer.ErrorMessage
+	}
+	if entry.Paused {
+		state.status = statusStopped
+		state.rate = 0
+		state.eta = -1
+	}
+	return state
+}
@@ -0,0 +1,185 @@
+package transmission
+
+import (
+	"testing"
+	"time"
+
+	"github.com/anonfunc/transmissio/internal/pkg/registry"
+	"github.com/anonfunc/transmissio/internal/pkg/torrent"
+	"github.com/igungor/go-putio/putio"
+)
+
+func Test_stateOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    registry.Entry
+		transfer putio.Transfer
+		size     int64 // Wanted, if not the transfer's size.
+		progress torrent.Progress
+		ok       bool
+		want     torrentState
+	}{
+		{
+			name:     "Queued on put.io",
+			transfer: putio.Transfer{Status: "IN_QUEUE", Size: 1000},
+			want:     torrentState{status: statusDownloadWait, size: 1000, eta: -1},
+		},
+		{
+			name:     "Downloading on put.io",
+			transfer: putio.Transfer{Status: "DOWNLOADING", Size: 1000, Downloaded: 500, DownloadSpeed: 10, EstimatedTime: 50},
+			progress: torrent.Progress{Phase: torrent.PhasePutIo},
+			ok:       true,
+			want:     torrentState{status: statusDownload, size: 1000, have: 250, rate: 10, eta: 50},
+		},
+		{
+			name:     "Completed on put.io",
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			progress: torrent.Progress{Phase: torrent.PhasePutIo},
+			ok:       true,
+			want:     torrentState{status: statusDownloadWait, size: 1000, have: 500, eta: -1},
+		},
+		{
+			name:     "In the local download queue",
+			transfer: putio.Transfer{Status: "SEEDING", Size: 1000, Downloaded: 1000},
+			progress: torrent.Progress{Phase: torrent.PhaseWaiting},
+			ok:       true,
+			want:     torrentState{status: statusDownloadWait, size: 1000, have: 500, eta: -1},
+		},
+		{
+			name:     "Downloading locally",
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			progress: torrent.Progress{Phase: torrent.PhaseLocal, LocalBytes: 600, LocalRate: 100},
+			ok:       true,
+			want:     torrentState{status: statusDownload, size: 1000, have: 800, rate: 100, eta: 4},
+		},
+		{
+			name:     "Downloading some files locally",
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			progress: torrent.Progress{Phase: torrent.PhaseLocal, LocalBytes: 100, LocalSize: 200, LocalRate: 50},
+			ok:       true,
+			want:     torrentState{status: statusDownload, size: 1000, have: 750, rate: 50, eta: 2},
+		},
+		{
+			name:     "Downloaded locally",
+			transfer: putio.Transfer{Status: "SEEDING", Size: 1000, Downloaded: 1000},
+			progress: torrent.Progress{Phase: torrent.PhaseDone},
+			ok:       true,
+			want:     torrentState{status: statusSeed, size: 1000, have: 1000, eta: -1, finished: true},
+		},
+		{
+			name:     "Downloaded before restart",
+			entry:    registry.Entry{DoneDate: time.Now()},
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			want:     torrentState{status: statusSeed, size: 1000, have: 1000, eta: -1, finished: true},
+		},
+		{
+			name:     "External transfer",
+			entry:    registry.Entry{External: true},
+			transfer: putio.Transfer{Status: "DOWNLOADING", Size: 1000, Downloaded: 500},
+			want:     torrentState{status: statusDownload, size: 1000, have: 500, eta: -1},
+		},
+		{
+			name:     "Local download failed",
+			entry:    registry.Entry{Error: torrent.ErrorLocal, ErrorString: "No space left on device"},
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			want: torrentState{status: statusStopped, size: 1000, have: 500, eta: -1,
+				errorCode: torrent.ErrorLocal, errorString: "No space left on device"},
+		},
+		{
+			name:     "put.io transfer failed",
+			transfer: putio.Transfer{Status: "ERROR", Size: 1000, Downloaded: 100, ErrorMessage: "No peers"},
+			want: torrentState{status: statusStopped, size: 1000, have: 50, eta: -1,
+				errorCode: torrent.ErrorTrackerError, errorString: "No peers"},
+		},
+		{
+			name:     "put.io warning",
+			transfer: putio.Transfer{Status: "DOWNLOADING", Size: 1000, Downloaded: 100, ErrorMessage: "Tracker unreachable"},
+			want: torrentState{status: statusDownload, size: 1000, have: 50, eta: -1,
+				errorCode: torrent.ErrorTrackerWarning, errorString: "Tracker unreachable"},
+		},
+		{
+			name:     "Some files wanted, downloading on put.io",
+			transfer: putio.Transfer{Status: "DOWNLOADING", Size: 1000, Downloaded: 500},
+			size:     200,
+			want:     torrentState{status: statusDownload, size: 200, have: 50, eta: -1},
+		},
+		{
+			name:     "Some files wanted, completed on put.io",
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			size:     200,
+			want:     torrentState{status: statusDownloadWait, size: 200, have: 100, eta: -1},
+		},
+		{
+			name:     "Some files wanted, downloading locally",
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			size:     200,
+			progress: torrent.Progress{Phase: torrent.PhaseLocal, LocalBytes: 100, LocalSize: 200, LocalRate: 50},
+			ok:       true,
+			want:     torrentState{status: statusDownload, size: 200, have: 150, rate: 50, eta: 2},
+		},
+		{
+			name:     "Some files wanted, downloaded locally",
+			transfer: putio.Transfer{Status: "SEEDING", Size: 1000, Downloaded: 1000},
+			size:     200,
+			progress: torrent.Progress{Phase: torrent.PhaseDone},
+			ok:       true,
+			want:     torrentState{status: statusSeed, size: 200, have: 200, eta: -1, finished: true},
+		},
+		{
+			name:     "Paused",
+			entry:    registry.Entry{Paused: true},
+			transfer: putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			progress: torrent.Progress{Phase: torrent.PhaseLocal, LocalBytes: 200, LocalRate: 100},
+			ok:       true,
+			want:     torrentState{status: statusStopped, size: 1000, have: 600, eta: -1},
+		},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			size := tt.size
+			if size == 0 {
+				size = int64(tt.transfer.Size)
+			}
+			if got := stateOf(tt.entry, tt.transfer, size, tt.progress, tt.ok); got != tt.want {
+				t.Errorf("stateOf() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_stateOf_unwantedFiles(t *testing.T) {
+	files := []putio.File{{Name: "Show/e01.mkv", Size: 100}, {Name: "Show/extras.mkv", Size: 900}}
+	entry := registry.Entry{Unwanted: []int{1}}
+	tests := []struct {
+		name     string
+		transfer putio.Transfer
+		progress torrent.Progress
+	}{
+		{"Downloading on put.io", putio.Transfer{Status: "DOWNLOADING", Size: 1000, Downloaded: 400}, torrent.Progress{Phase: torrent.PhasePutIo}},
+		{"Completed on put.io", putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000}, torrent.Progress{Phase: torrent.PhasePutIo}},
+		{"Downloading locally", putio.Transfer{Status: "COMPLETED", Size: 1000, Downloaded: 1000},
+			torrent.Progress{Phase: torrent.PhaseLocal, LocalBytes: 40, LocalSize: 100}},
+		{"Downloaded locally", putio.Transfer{Status: "SEEDING", Size: 1000, Downloaded: 1000}, torrent.Progress{Phase: torrent.PhaseDone}},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			sizeWhenDone := wantedSize(entry, files, int64(tt.transfer.Size))
+			state := stateOf(entry, tt.transfer, sizeWhenDone, tt.progress, true)
+			if state.size != sizeWhenDone {
+				t.Errorf("size = %d, want sizeWhenDone %d", state.size, sizeWhenDone)
+			}
+			if state.have+state.left() != sizeWhenDone {
+				t.Errorf("haveValid %d + leftUntilDone %d != sizeWhenDone %d", state.have, state.left(), sizeWhenDone)
+			}
+			var completed int64
+			for i, f := range files {
+				completed += bytesCompleted(entry, i, f, state)
+			}
+			if completed < state.have-1 || completed > state.have {
+				t.Errorf("files' bytesCompleted add up to %d, want haveValid %d", completed, state.have)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package transmission
+
+import (
+	"reflect"
+	"strings"
+)
+
+// torrentInfoFields maps each torrent-get field name to its TorrentInfo field index.
+var torrentInfoFields = func() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(TorrentInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// fieldValue returns the value of a torrent-get field, dereferenced and with
+// empty lists rather than nulls, or nil for fields we don't know.
+func fieldValue(info TorrentInfo, field string) interface{} {
+	i, ok := torrentInfoFields[field]
+	if !ok {
+		return nil
+	}
+	v := reflect.ValueOf(info).Field(i)
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return reflect.Zero(v.Type().Elem()).Interface()
+		}
+		return v.Elem().Interface()
+	case reflect.Slice:
+		if v.IsNil() {
+			return reflect.MakeSlice(v.Type(), 0, 0).Interface()
+		}
+	}
+	return v.Interface()
+}
+
+// torrentObject holds every requested field we know, even when zero.
+func torrentObject(info TorrentInfo, fields []string) map[string]interface{} {
+	object := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		if _, ok := torrentInfoFields[field]; ok {
+			object[field] = fieldValue(info, field)
+		}
+	}
+	return object
+}
+
+func torrentRow(info TorrentInfo, fields []string) []interface{} {
+	row := make([]interface{}, len(fields))
+	for i, field := range fields {
+		row[i] = fieldValue(info, field)
+	}
+	return row
+}
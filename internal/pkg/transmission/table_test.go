@@ -0,0 +1,59 @@
+package transmission
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_torrentObjectAndRow(t *testing.T) {
+	rate := int64(1000)
+	info := TorrentInfo{ID: 7, Name: "Show", RateDownload: &rate, Files: []FileInfo{{Name: "Show/episode.mkv", Length: 10}}}
+	tests := []struct {
+		name       string
+		fields     []string
+		wantObject map[string]interface{}
+		wantRow    []interface{}
+	}{
+		{
+			name:       "Set fields",
+			fields:     []string{"id", "name", "rateDownload"},
+			wantObject: map[string]interface{}{"id": int64(7), "name": "Show", "rateDownload": int64(1000)},
+			wantRow:    []interface{}{int64(7), "Show", int64(1000)},
+		},
+		{
+			name:       "Zero values are kept",
+			fields:     []string{"eta", "error", "isFinished"},
+			wantObject: map[string]interface{}{"eta": int64(0), "error": int64(0), "isFinished": false},
+			wantRow:    []interface{}{int64(0), int64(0), false},
+		},
+		{
+			name:       "Lists are never null",
+			fields:     []string{"files", "labels"},
+			wantObject: map[string]interface{}{"files": info.Files, "labels": []string{}},
+			wantRow:    []interface{}{info.Files, []string{}},
+		},
+		{
+			name:       "Unknown fields",
+			fields:     []string{"id", "trackers"},
+			wantObject: map[string]interface{}{"id": int64(7)},
+			wantRow:    []interface{}{int64(7), nil},
+		},
+		{
+			name:       "No fields",
+			fields:     nil,
+			wantObject: map[string]interface{}{},
+			wantRow:    []interface{}{},
+		},
+	}
+	for _, tt2 := range tests {
+		tt := tt2
+		t.Run(tt.name, func(t *testing.T) {
+			if got := torrentObject(info, tt.fields); !reflect.DeepEqual(got, tt.wantObject) {
+				t.Errorf("torrentObject() = %#v, want %#v", got, tt.wantObject)
+			}
+			if got := torrentRow(info, tt.fields); !reflect.DeepEqual(got, tt.wantRow) {
+				t.Errorf("torrentRow() = %#v, want %#v", got, tt.wantRow)
+			}
+		})
+	}
+}
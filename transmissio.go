@@ -8,7 +8,6 @@ import (
 
 	"github.com/anonfunc/transmissio/internal/pkg/blackhole"
 	"github.com/anonfunc/transmissio/internal/pkg/config"
-	"github.com/spf13/viper"
 
 	"github.com/anonfunc/transmissio/internal/pkg/transmission"
 )
@@ -19,10 +18,10 @@ func main() {
 	downloader := torrent.NewDownloader()
 	transmission.Downloader = downloader
 	go func() {
-		blackhole.StartWatcher(downloader, viper.GetString("blackhole"))
+		blackhole.StartWatcher(downloader, config.GetString("blackhole"))
 	}()
 	http.HandleFunc("/transmission/rpc", transmission.RPCHandler)
-	listeningOn := viper.GetString("host") + ":" + viper.GetString("port")
+	listeningOn := config.GetString("host") + ":" + config.GetString("port")
 	log.Printf("Listening on %s...", listeningOn)
 	log.Fatal(http.ListenAndServe(listeningOn, nil))
 }